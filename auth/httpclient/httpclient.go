@@ -0,0 +1,157 @@
+// Copyright 2014-2016 Fraunhofer Institute for Applied Information Technology FIT
+
+// Package httpclient creates HTTP clients for the communication with authentication providers
+//	It allows trusting a private CA, authenticating with a client certificate (mutual TLS), using a proxy, and
+//	bounding the time of requests, without changing http.DefaultClient or http.DefaultTransport.
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Default values of Conf
+const (
+	DefaultTimeout     = 30
+	DefaultDialTimeout = 10
+)
+
+// Conf configures the HTTP client for requests to a provider
+type Conf struct {
+	// CAFile is the path of a PEM file with CA certificates to trust, in addition to the system's (optional)
+	CAFile string `json:"caFile"`
+	// CertFile is the path of the PEM encoded client certificate for mutual TLS (optional)
+	CertFile string `json:"certFile"`
+	// KeyFile is the path of the PEM encoded private key of the client certificate (optional)
+	KeyFile string `json:"keyFile"`
+	// ProxyURL is the URL of the proxy (optional)
+	//	By default, the proxy is taken from the HTTP_PROXY, HTTPS_PROXY, and NO_PROXY environment variables.
+	ProxyURL string `json:"proxyURL"`
+	// Timeout is the time limit in seconds for a request, including reading the response (default 30)
+	Timeout int `json:"timeout"`
+	// DialTimeout is the time limit in seconds to establish a connection (default 10)
+	DialTimeout int `json:"dialTimeout"`
+}
+
+// Validate validates the configuration
+func (c Conf) Validate() error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return errors.New("certFile and keyFile must be specified together")
+	}
+	if c.ProxyURL != "" {
+		if _, err := url.Parse(c.ProxyURL); err != nil {
+			return errors.New("proxyURL is invalid: " + err.Error())
+		}
+	}
+	if c.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	if c.DialTimeout < 0 {
+		return errors.New("dialTimeout must not be negative")
+	}
+	return nil
+}
+
+// defaultTransport returns a clone of http.DefaultTransport, or a transport with its standard settings
+func defaultTransport() *http.Transport {
+	if transport, ok := http.DefaultTransport.(*http.Transport); ok {
+		return transport.Clone()
+	}
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// New returns a client as configured
+//	The transport is based on http.DefaultTransport, which is not modified. If the application replaced it with a
+//	RoundTripper other than *http.Transport, e.g. for tracing, the transport is based on the standard defaults instead.
+func New(conf Conf) (*http.Client, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+
+	transport := defaultTransport()
+
+	if conf.ProxyURL != "" {
+		proxyURL, _ := url.Parse(conf.ProxyURL)
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	dialTimeout := conf.DialTimeout
+	if dialTimeout == 0 {
+		dialTimeout = DefaultDialTimeout
+	}
+	transport.DialContext = (&net.Dialer{
+		Timeout:   time.Duration(dialTimeout) * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+
+	if conf.CAFile != "" || conf.CertFile != "" {
+		tlsConfig := &tls.Config{}
+		if conf.CAFile != "" {
+			pool, err := loadCertPool(conf.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.RootCAs = pool
+		}
+		if conf.CertFile != "" {
+			cert, err := tls.LoadX509KeyPair(conf.CertFile, conf.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("error loading client certificate: %s", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	timeout := conf.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(timeout) * time.Second,
+	}, nil
+}
+
+// loadCertPool returns the system's certificate pool with the certificates of the PEM file added
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA file: %s", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		// e.g. not available on Windows before Go 1.18
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in CA file %s", path)
+	}
+	return pool, nil
+}
+
+// Resolve returns the client to use given an optional client and an optional configuration
+//	The client takes precedence over the configuration. If neither is given, it returns http.DefaultClient.
+func Resolve(client *http.Client, conf *Conf) (*http.Client, error) {
+	if client != nil {
+		return client, nil
+	}
+	if conf != nil {
+		return New(*conf)
+	}
+	return http.DefaultClient, nil
+}
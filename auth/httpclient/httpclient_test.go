@@ -0,0 +1,180 @@
+package httpclient
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writePEM writes a PEM block to a file in dir and returns its path
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	path := filepath.Join(dir, name)
+	err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", name, err)
+	}
+	return path
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "httpclient")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	return dir
+}
+
+func TestNewCAFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	client, err := New(Conf{})
+	if err != nil {
+		t.Fatalf("Error creating client: %s", err)
+	}
+	if _, err := client.Get(server.URL); err == nil {
+		t.Fatalf("Expected the server certificate to be rejected without the CA file")
+	}
+
+	client, err = New(Conf{CAFile: writePEM(t, dir, "ca.pem", "CERTIFICATE", server.Certificate().Raw)})
+	if err != nil {
+		t.Fatalf("Error creating client: %s", err)
+	}
+	res, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Error with CA file: %s", err)
+	}
+	res.Body.Close()
+}
+
+func TestNewClientCertificate(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 || r.TLS.PeerCertificates[0].Subject.CommonName != "test-client" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test-client"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %s", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Error marshaling key: %s", err)
+	}
+
+	client, err := New(Conf{
+		CAFile:   writePEM(t, dir, "ca.pem", "CERTIFICATE", server.Certificate().Raw),
+		CertFile: writePEM(t, dir, "cert.pem", "CERTIFICATE", der),
+		KeyFile:  writePEM(t, dir, "key.pem", "EC PRIVATE KEY", keyDER),
+	})
+	if err != nil {
+		t.Fatalf("Error creating client: %s", err)
+	}
+	res, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Error with client certificate: %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Client certificate was not presented: %d", res.StatusCode)
+	}
+}
+
+func TestNewProxy(t *testing.T) {
+	hosts := make(chan string, 1)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts <- r.URL.Host
+	}))
+	defer proxy.Close()
+
+	client, err := New(Conf{ProxyURL: proxy.URL, Timeout: 5})
+	if err != nil {
+		t.Fatalf("Error creating client: %s", err)
+	}
+	res, err := client.Get("http://provider.example.com/token")
+	if err != nil {
+		t.Fatalf("Error with proxy: %s", err)
+	}
+	res.Body.Close()
+	if host := <-hosts; host != "provider.example.com" {
+		t.Fatalf("Request was not sent through the proxy, got host %q", host)
+	}
+}
+
+// wrappedTransport is a RoundTripper that replaces http.DefaultTransport, e.g. for tracing
+type wrappedTransport struct {
+	http.RoundTripper
+}
+
+func TestNewWrappedDefaultTransport(t *testing.T) {
+	defaultTransport := http.DefaultTransport
+	http.DefaultTransport = wrappedTransport{defaultTransport}
+	defer func() { http.DefaultTransport = defaultTransport }()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	client, err := New(Conf{Timeout: 5})
+	if err != nil {
+		t.Fatalf("Error creating client: %s", err)
+	}
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok || transport.Proxy == nil || transport.TLSHandshakeTimeout == 0 {
+		t.Fatalf("Transport without the standard settings: %+v", client.Transport)
+	}
+	res, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Error with request: %s", err)
+	}
+	res.Body.Close()
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		conf  Conf
+		valid bool
+	}{
+		{Conf{}, true},
+		{Conf{CertFile: "cert.pem", KeyFile: "key.pem"}, true},
+		{Conf{CertFile: "cert.pem"}, false},
+		{Conf{KeyFile: "key.pem"}, false},
+		{Conf{ProxyURL: "://proxy"}, false},
+		{Conf{Timeout: -1}, false},
+		{Conf{DialTimeout: -1}, false},
+	} {
+		if err := tc.conf.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%+v: expected valid=%t, got error: %v", tc.conf, tc.valid, err)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2014-2016 Fraunhofer Institute for Applied Information Technology FIT
+
+// Package validator implements validation of opaque tokens using OAuth 2.0 Token Introspection (RFC 7662)
+//	The provider URL is the introspection endpoint, which is called with the client ID and secret of the validator.
+package validator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/linksmart/go-sec/auth/validator"
+	"github.com/linksmart/go-sec/authz"
+)
+
+const DriverName = "introspection"
+
+// IntrospectionValidator validates tokens by introspecting them at the authorization server
+//	Introspection responses are cached, so that a token is not introspected on every request.
+//	It is safe for concurrent use.
+type IntrospectionValidator struct {
+	// Client is the HTTP client for requests to the introspection endpoint, http.DefaultClient if nil
+	Client *http.Client
+
+	clientID     string
+	clientSecret string
+	cache        *validator.ResultCache
+}
+
+// NewIntrospectionValidator returns a validator that authenticates to the introspection endpoint with the given
+//	client credentials and caches the responses according to the cache configuration
+func NewIntrospectionValidator(clientID, clientSecret string, cache validator.CacheConf) *IntrospectionValidator {
+	return &IntrospectionValidator{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		cache:        validator.NewResultCache(cache),
+	}
+}
+
+func init() {
+	// Register the driver as a auth/validator
+	validator.RegisterFactory(DriverName, func(conf validator.Conf) (validator.Driver, error) {
+		if conf.ClientSecret == "" {
+			return nil, fmt.Errorf("client secret is required to call the introspection endpoint")
+		}
+		var cache validator.CacheConf
+		if conf.Cache != nil {
+			cache = *conf.Cache
+		}
+		v := NewIntrospectionValidator(conf.ClientID, conf.ClientSecret, cache)
+		v.Client = conf.HTTPClient
+		return v, nil
+	})
+}
+
+// Validate validates the token
+//	The serverAddr is the introspection endpoint, e.g. https://auth.example.com/oauth2/introspect
+func (v *IntrospectionValidator) Validate(serverAddr, clientID, tokenString string) (bool, *authz.Claims, error) {
+	return v.ValidateContext(context.Background(), serverAddr, clientID, tokenString)
+}
+
+// ValidateContext validates the token, with the context bounding the introspection request
+func (v *IntrospectionValidator) ValidateContext(ctx context.Context, serverAddr, clientID, tokenString string) (bool, *authz.Claims, error) {
+	if valid, claims, found := v.cache.Get(tokenString); found {
+		return valid, claims, nil
+	}
+
+	fields, err := v.introspect(ctx, serverAddr, tokenString)
+	if err != nil {
+		return false, nil, fmt.Errorf("error introspecting the token: %s", err)
+	}
+
+	valid, claims := claimsFromResponse(fields)
+	v.cache.Add(tokenString, valid, claims)
+	return valid, claims, nil
+}
+
+// introspect posts the token to the introspection endpoint and returns the fields of the response
+func (v *IntrospectionValidator) introspect(ctx context.Context, serverAddr, tokenString string) (map[string]interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, serverAddr, strings.NewReader(url.Values{
+		"token":           {tokenString},
+		"token_type_hint": {"access_token"},
+	}.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(v.clientID), url.QueryEscape(v.clientSecret))
+
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%d %s", res.StatusCode, http.StatusText(res.StatusCode))
+	}
+
+	var fields map[string]interface{}
+	decoder := json.NewDecoder(res.Body)
+	decoder.UseNumber()
+	err = decoder.Decode(&fields)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding the introspection response: %s", err)
+	}
+	return fields, nil
+}
+
+// claimsFromResponse maps the introspection response onto the claims
+//	The username is taken from username, or sub if not given, and the roles from the roles claim. Scopes are not
+//	roles, rules match them as the scope claim, which is kept with all other fields in the extra claims.
+func claimsFromResponse(fields map[string]interface{}) (bool, *authz.Claims) {
+	if active, _ := fields["active"].(bool); !active {
+		return false, &authz.Claims{Status: "token is not active"}
+	}
+	if exp, ok := fields["exp"].(json.Number); ok {
+		if v, err := exp.Int64(); err == nil && time.Now().Unix() >= v {
+			return false, &authz.Claims{Status: "token is expired"}
+		}
+	}
+	if nbf, ok := fields["nbf"].(json.Number); ok {
+		if v, err := nbf.Int64(); err == nil && time.Now().Unix() < v {
+			return false, &authz.Claims{Status: "token is not active yet"}
+		}
+	}
+
+	claims := &authz.Claims{
+		Username: stringField(fields, "username"),
+		Groups:   stringsField(fields, "groups"),
+		Roles:    stringsField(fields, "roles"),
+		ClientID: stringField(fields, "client_id"),
+		Extra:    fields,
+	}
+	if claims.Username == "" {
+		claims.Username = stringField(fields, "sub")
+	}
+	return true, claims
+}
+
+func stringField(fields map[string]interface{}, name string) string {
+	s, _ := fields[name].(string)
+	return s
+}
+
+func stringsField(fields map[string]interface{}, name string) []string {
+	values, _ := fields[name].([]interface{})
+	var strs []string
+	for _, value := range values {
+		if s, ok := value.(string); ok {
+			strs = append(strs, s)
+		}
+	}
+	return strs
+}
@@ -0,0 +1,129 @@
+package validator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/linksmart/go-sec/auth/validator"
+	"github.com/linksmart/go-sec/authz"
+)
+
+const (
+	testClientID     = "test-client"
+	testClientSecret = "secret"
+)
+
+// testEndpoint is a local introspection endpoint with fixed responses by token
+type testEndpoint struct {
+	*httptest.Server
+	mu        sync.Mutex
+	responses map[string]map[string]interface{}
+	requests  int
+}
+
+func newTestEndpoint(t *testing.T, responses map[string]map[string]interface{}) *testEndpoint {
+	endpoint := &testEndpoint{responses: responses}
+	endpoint.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endpoint.mu.Lock()
+		endpoint.requests++
+		endpoint.mu.Unlock()
+
+		if id, secret, ok := r.BasicAuth(); !ok || id != testClientID || secret != testClientSecret {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		response, found := endpoint.responses[r.PostFormValue("token")]
+		if !found {
+			response = map[string]interface{}{"active": false}
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	return endpoint
+}
+
+func (endpoint *testEndpoint) count() int {
+	endpoint.mu.Lock()
+	defer endpoint.mu.Unlock()
+	return endpoint.requests
+}
+
+func TestValidate(t *testing.T) {
+	endpoint := newTestEndpoint(t, map[string]map[string]interface{}{
+		"user-token": {
+			"active":    true,
+			"sub":       "Z5O3upPC88QrAjx00dis",
+			"username":  "john",
+			"scope":     "read write",
+			"client_id": "app",
+			"groups":    []string{"admin"},
+			"roles":     []string{"editor"},
+			"exp":       time.Now().Add(time.Hour).Unix(),
+		},
+		"service-token": {
+			"active":    true,
+			"sub":       "service",
+			"client_id": "service",
+		},
+		"expired-token": {
+			"active": true,
+			"sub":    "john",
+			"exp":    time.Now().Add(-time.Minute).Unix(),
+		},
+	})
+	defer endpoint.Close()
+
+	v := NewIntrospectionValidator(testClientID, testClientSecret, validator.CacheConf{})
+
+	valid, claims, err := v.Validate(endpoint.URL, testClientID, "user-token")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !valid {
+		t.Fatalf("Active token not valid: %s", claims.Status)
+	}
+	if claims.Username != "john" || claims.ClientID != "app" ||
+		len(claims.Groups) != 1 || claims.Groups[0] != "admin" ||
+		len(claims.Roles) != 1 || claims.Roles[0] != "editor" {
+		t.Fatalf("Unexpected claims: %+v", claims)
+	}
+	// scopes are matched as claims, not as roles
+	rules := authz.Rules{{Paths: []string{"/docs"}, Methods: []string{"PUT"}, Claims: map[string][]string{"scope": {"write"}}}}
+	if !rules.Authorized("/docs", "PUT", claims) {
+		t.Fatalf("Scope not matched as claim: %+v", claims)
+	}
+	rules = authz.Rules{{Paths: []string{"/docs"}, Methods: []string{"PUT"}, Roles: []string{"write"}}}
+	if rules.Authorized("/docs", "PUT", claims) {
+		t.Fatalf("Scope matched as role: %+v", claims)
+	}
+
+	// responses are cached
+	v.Validate(endpoint.URL, testClientID, "user-token")
+	if n := endpoint.count(); n != 1 {
+		t.Fatalf("Expected a single introspection request, got %d", n)
+	}
+
+	valid, claims, err = v.Validate(endpoint.URL, testClientID, "service-token")
+	if err != nil || !valid || claims.Username != "service" {
+		t.Fatalf("Unexpected result for service token: %t %+v %v", valid, claims, err)
+	}
+
+	for _, token := range []string{"expired-token", "unknown-token"} {
+		valid, claims, err = v.Validate(endpoint.URL, testClientID, token)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", token, err)
+		}
+		if valid || claims.Status == "" {
+			t.Fatalf("%s: token was accepted", token)
+		}
+	}
+
+	// wrong client credentials
+	v = NewIntrospectionValidator(testClientID, "wrong", validator.CacheConf{})
+	if _, _, err = v.Validate(endpoint.URL, testClientID, "user-token"); err == nil {
+		t.Fatalf("Expected error for rejected client credentials")
+	}
+}
@@ -0,0 +1,168 @@
+package jose
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Default intervals of KeyCache
+const (
+	DefaultRefreshInterval    = 15 * time.Minute
+	DefaultMinRefreshInterval = 10 * time.Second
+)
+
+// backgroundTimeout bounds the fetches of a background refresh
+const backgroundTimeout = time.Minute
+
+// KeyProvider provides verification keys by key ID
+type KeyProvider interface {
+	// Key returns the key with the given ID
+	Key(kid string) (Key, error)
+}
+
+// ContextKeyProvider is a KeyProvider that may fetch keys within the given context
+type ContextKeyProvider interface {
+	KeyProvider
+	// KeyContext returns the key with the given ID
+	KeyContext(ctx context.Context, kid string) (Key, error)
+}
+
+// Key returns the key with the given ID
+func (s *KeySet) Key(kid string) (Key, error) {
+	key, found := s.Lookup(kid)
+	if !found {
+		return Key{}, fmt.Errorf("unknown signing key: %s", kid)
+	}
+	return key, nil
+}
+
+// KeyCache keeps the keys of a provider to support key rotation
+//	The keys are refreshed in the background once they are older than RefreshInterval, and immediately when an unknown
+//	key is requested. Fetches are never attempted more often than MinRefreshInterval to avoid stampedes on the provider.
+//	When the provider is unreachable, the cached keys remain in use.
+type KeyCache struct {
+	RefreshInterval    time.Duration
+	MinRefreshInterval time.Duration
+
+	fetch func(ctx context.Context) (*KeySet, error)
+
+	mu         sync.Mutex
+	keys       *KeySet
+	fetched    time.Time // time of the last successful fetch
+	attempted  time.Time // time of the last fetch attempt
+	lastErr    error     // error of the last fetch attempt
+	refreshing bool      // a background refresh is in progress
+	// fetching serializes fetches, waiting for it is bounded by the context
+	fetching chan struct{}
+}
+
+// NewKeyCache returns a key cache that gets keys using the given fetch function
+//	The context passed to fetch is the one of the request that triggered the fetch, or a background context with a
+//	timeout for background refreshes.
+func NewKeyCache(fetch func(ctx context.Context) (*KeySet, error)) *KeyCache {
+	return &KeyCache{
+		RefreshInterval:    DefaultRefreshInterval,
+		MinRefreshInterval: DefaultMinRefreshInterval,
+		fetch:              fetch,
+		fetching:           make(chan struct{}, 1),
+	}
+}
+
+// Keys returns the cached keys, fetching them if not available yet
+//	The keys are refreshed in the background when they are older than RefreshInterval.
+func (c *KeyCache) Keys() (*KeySet, error) {
+	return c.KeysContext(context.Background())
+}
+
+// KeysContext is like Keys, with the context bounding the fetch
+func (c *KeyCache) KeysContext(ctx context.Context) (*KeySet, error) {
+	c.mu.Lock()
+	keys := c.keys
+	if keys != nil && time.Since(c.fetched) > c.RefreshInterval && !c.refreshing {
+		// refresh in the background and keep serving the cached keys meanwhile
+		c.refreshing = true
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), backgroundTimeout)
+			c.refresh(ctx, true)
+			cancel()
+			c.mu.Lock()
+			c.refreshing = false
+			c.mu.Unlock()
+		}()
+	}
+	c.mu.Unlock()
+
+	if keys != nil {
+		return keys, nil
+	}
+	return c.refresh(ctx, false)
+}
+
+// Key returns the key with the given ID, fetching the keys when necessary
+func (c *KeyCache) Key(kid string) (Key, error) {
+	return c.KeyContext(context.Background(), kid)
+}
+
+// KeyContext is like Key, with the context bounding the fetch
+func (c *KeyCache) KeyContext(ctx context.Context, kid string) (Key, error) {
+	keys, err := c.KeysContext(ctx)
+	if err != nil {
+		return Key{}, fmt.Errorf("error getting keys: %s", err)
+	}
+	if key, found := keys.Lookup(kid); found {
+		return key, nil
+	}
+
+	// the key may have been rotated
+	keys, err = c.refresh(ctx, false)
+	if err != nil {
+		if ctx.Err() != nil {
+			return Key{}, fmt.Errorf("error getting keys: %s", err)
+		}
+		return Key{}, fmt.Errorf("unknown signing key: %s", kid)
+	}
+	return keys.Key(kid)
+}
+
+// refresh fetches the keys, unless this was attempted recently
+//	It returns the keys in the cache, which remain unchanged when the fetch fails. Failures caused by the context of a
+//	request are not recorded, as they say nothing about the provider; those of background refreshes are.
+func (c *KeyCache) refresh(ctx context.Context, background bool) (*KeySet, error) {
+	select {
+	case c.fetching <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-c.fetching }()
+
+	c.mu.Lock()
+	if !c.attempted.IsZero() && time.Since(c.attempted) < c.MinRefreshInterval {
+		defer c.mu.Unlock()
+		if c.keys == nil {
+			return nil, c.lastErr
+		}
+		return c.keys, nil
+	}
+	c.mu.Unlock()
+
+	attempted := time.Now()
+	keys, err := c.fetch(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil && ctx.Err() != nil && !background {
+		return nil, err
+	}
+	c.attempted, c.lastErr = attempted, err
+	if err != nil {
+		if c.keys == nil {
+			return nil, err
+		}
+		return c.keys, nil
+	}
+	c.keys = keys
+	c.fetched = time.Now()
+	return keys, nil
+}
@@ -0,0 +1,177 @@
+package jose
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testProvider serves key sets and counts the fetches
+type testProvider struct {
+	sync.Mutex
+	keys    *KeySet
+	err     error
+	fetches int
+}
+
+func (p *testProvider) fetch(ctx context.Context) (*KeySet, error) {
+	p.Lock()
+	defer p.Unlock()
+	p.fetches++
+	return p.keys, p.err
+}
+
+func (p *testProvider) set(keys *KeySet, err error) {
+	p.Lock()
+	defer p.Unlock()
+	p.keys, p.err = keys, err
+}
+
+func (p *testProvider) count() int {
+	p.Lock()
+	defer p.Unlock()
+	return p.fetches
+}
+
+func keySet(kids ...string) *KeySet {
+	set := &KeySet{}
+	for _, kid := range kids {
+		set.Keys = append(set.Keys, Key{ID: kid})
+	}
+	return set
+}
+
+func TestKeyCacheRotation(t *testing.T) {
+	provider := &testProvider{keys: keySet("k1")}
+	cache := NewKeyCache(provider.fetch)
+	cache.MinRefreshInterval = 0
+
+	if _, err := cache.Key("k1"); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	// rotate
+	provider.set(keySet("k2"), nil)
+	if _, err := cache.Key("k2"); err != nil {
+		t.Fatalf("Rotated key not found: %s", err)
+	}
+	if _, err := cache.Key("k1"); err == nil {
+		t.Fatalf("Expected error for removed key")
+	}
+}
+
+func TestKeyCacheRateLimit(t *testing.T) {
+	provider := &testProvider{keys: keySet("k1")}
+	cache := NewKeyCache(provider.fetch)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache.Key("unknown")
+		}()
+	}
+	wg.Wait()
+
+	if n := provider.count(); n != 1 {
+		t.Fatalf("Expected a single fetch, got %d", n)
+	}
+}
+
+func TestKeyCacheUnreachable(t *testing.T) {
+	provider := &testProvider{err: errors.New("unreachable")}
+	cache := NewKeyCache(provider.fetch)
+	cache.MinRefreshInterval = 0
+
+	if _, err := cache.Key("k1"); err == nil {
+		t.Fatalf("Expected error without keys")
+	}
+
+	provider.set(keySet("k1"), nil)
+	if _, err := cache.Key("k1"); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	// keep serving cached keys while the provider is unreachable
+	provider.set(nil, errors.New("unreachable"))
+	cache.RefreshInterval = 0
+	for i := 0; i < 3; i++ {
+		if _, err := cache.Key("k1"); err != nil {
+			t.Fatalf("Cached key not served: %s", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for deadline := time.Now().Add(time.Second); provider.count() < 3; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected refresh attempts, got %d fetches", provider.count())
+		}
+	}
+}
+
+func TestKeyCacheContext(t *testing.T) {
+	hang := make(chan struct{})
+	defer close(hang)
+	var hanging bool
+	var mu sync.Mutex
+	cache := NewKeyCache(func(ctx context.Context) (*KeySet, error) {
+		mu.Lock()
+		h := hanging
+		mu.Unlock()
+		if h {
+			// a provider that does not respond, ignoring the context
+			<-hang
+			return nil, errors.New("hung up")
+		}
+		return keySet("k1"), nil
+	})
+	cache.MinRefreshInterval = 0
+
+	if _, err := cache.Key("k1"); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	// start a background refresh that hangs
+	mu.Lock()
+	hanging = true
+	mu.Unlock()
+	cache.RefreshInterval = 0
+	if _, err := cache.Key("k1"); err != nil {
+		t.Fatalf("Cached key not served: %s", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	// waiting for the hanging fetch is bounded by the request context
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := cache.KeyContext(ctx, "k2"); err == nil {
+		t.Fatalf("Expected error for unknown key")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Waited %s for the hanging fetch", elapsed)
+	}
+}
+
+func TestKeyCacheCancelled(t *testing.T) {
+	provider := &testProvider{keys: keySet("k1")}
+	cache := NewKeyCache(func(ctx context.Context) (*KeySet, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return provider.fetch(ctx)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := cache.KeyContext(ctx, "k1"); err == nil {
+		t.Fatalf("Expected error with cancelled context")
+	}
+
+	// the cancelled fetch does not hold back the next one
+	if _, err := cache.Key("k1"); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+}
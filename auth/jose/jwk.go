@@ -0,0 +1,205 @@
+// Copyright 2014-2016 Fraunhofer Institute for Applied Information Technology FIT
+
+// Package jose provides JSON Web Key (JWK) handling and token verification for validator drivers
+package jose
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+)
+
+// Key is a public key with its JWK attributes
+type Key struct {
+	// ID is the key ID (kid)
+	ID string
+	// Algorithm is the intended algorithm of the key (alg), if specified
+	Algorithm string
+	// Use is the intended use of the key (use), e.g. sig
+	Use string
+	// Key is the public key, one of *rsa.PublicKey, *ecdsa.PublicKey, or ed25519.PublicKey
+	Key crypto.PublicKey
+}
+
+// KeySet is a set of public keys
+type KeySet struct {
+	Keys []Key
+}
+
+// jwk is the JSON representation of a key (RFC 7517)
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC and OKP
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseKeySet parses a JWK Set document
+//	Keys of unsupported types and keys intended for encryption are skipped.
+func ParseKeySet(data []byte) (*KeySet, error) {
+	var set struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	err := json.Unmarshal(data, &set)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding key set: %s", err)
+	}
+
+	keySet := &KeySet{}
+	for _, raw := range set.Keys {
+		key, err := ParseKey(raw)
+		if err == errUnsupportedKey {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		keySet.Keys = append(keySet.Keys, *key)
+	}
+	return keySet, nil
+}
+
+var errUnsupportedKey = errors.New("unsupported key type")
+
+// ParseKey parses a single JWK
+//	RSA, EC (with P-256, P-384, and P-521 curves), and OKP (with Ed25519 curve) keys are supported.
+func ParseKey(data []byte) (*Key, error) {
+	var k jwk
+	err := json.Unmarshal(data, &k)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding key: %s", err)
+	}
+
+	key := &Key{
+		ID:        k.Kid,
+		Algorithm: k.Alg,
+		Use:       k.Use,
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus of RSA key %s: %s", k.Kid, err)
+		}
+		e, err := decodeInt(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, fmt.Errorf("invalid exponent of RSA key %s", k.Kid)
+		}
+		key.Key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errUnsupportedKey
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate of EC key %s: %s", k.Kid, err)
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate of EC key %s: %s", k.Kid, err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("invalid EC key %s: point is not on curve", k.Kid)
+		}
+		key.Key = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, errUnsupportedKey
+		}
+		x, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(k.X, "="))
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid public key of OKP key %s", k.Kid)
+		}
+		key.Key = ed25519.PublicKey(x)
+	default:
+		return nil, errUnsupportedKey
+	}
+	return key, nil
+}
+
+// FetchKeySet retrieves and parses a JWK Set document from the given URL
+func FetchKeySet(url string) (*KeySet, error) {
+	return FetchKeySetContext(context.Background(), url)
+}
+
+// FetchKeySetContext is like FetchKeySet, with the context bounding the request
+func FetchKeySetContext(ctx context.Context, url string) (*KeySet, error) {
+	return FetchKeySetWithClient(ctx, http.DefaultClient, url)
+}
+
+// FetchKeySetWithClient is like FetchKeySetContext, sending the request with the given client
+func FetchKeySetWithClient(ctx context.Context, client *http.Client, url string) (*KeySet, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error getting the key set: %s", err)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error getting the key set: %s", err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the key set: %s", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error getting the key set: %d %s", res.StatusCode, http.StatusText(res.StatusCode))
+	}
+	return ParseKeySet(body)
+}
+
+// Lookup returns the key with the given ID
+//	When the set has only one key and either the given ID or the key's ID is empty, that key is returned.
+func (s *KeySet) Lookup(kid string) (Key, bool) {
+	if len(s.Keys) == 1 && (kid == "" || s.Keys[0].ID == "") {
+		return s.Keys[0], true
+	}
+	for _, key := range s.Keys {
+		if key.ID == kid {
+			return key, true
+		}
+	}
+	return Key{}, false
+}
+
+// decodeInt decodes a base64url-encoded big-endian unsigned integer
+func decodeInt(s string) (*big.Int, error) {
+	if s == "" {
+		return nil, fmt.Errorf("missing value")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,69 @@
+package jose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+)
+
+// ParsePEM parses the public keys in PEM encoded data
+//	The blocks may be public keys (PUBLIC KEY or RSA PUBLIC KEY) or certificates (CERTIFICATE).
+//	Other blocks are skipped.
+func ParsePEM(data []byte) ([]crypto.PublicKey, error) {
+	var keys []crypto.PublicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		var key crypto.PublicKey
+		var err error
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			cert, err = x509.ParseCertificate(block.Bytes)
+			if err == nil {
+				key, err = supportedKey(cert.PublicKey)
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s block: %s", block.Type, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no public key found")
+	}
+	return keys, nil
+}
+
+// ParsePKIXPublicKey parses a DER encoded public key
+//	RSA, ECDSA, and Ed25519 keys are supported.
+func ParsePKIXPublicKey(der []byte) (crypto.PublicKey, error) {
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	return supportedKey(key)
+}
+
+// supportedKey checks that the key can be used to verify tokens
+func supportedKey(key crypto.PublicKey) (crypto.PublicKey, error) {
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type: %T", key)
+}
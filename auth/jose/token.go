@@ -0,0 +1,163 @@
+package jose
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"fmt"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// Algorithms are the supported signing algorithms (RFC 7518 and RFC 8037)
+var Algorithms = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
+}
+
+// ParseToken parses a signed token, verifies its signature, and validates its time-based claims (exp, nbf, iat)
+//	When the token is invalid, it returns the reason in status instead of the claims.
+func ParseToken(tokenString string, keyFunc jwt.Keyfunc) (claims jwt.MapClaims, status string) {
+	token, err := jwt.Parse(tokenString, keyFunc)
+	if err != nil {
+		if ve, ok := err.(*jwt.ValidationError); ok {
+			if ve.Errors&jwt.ValidationErrorMalformed != 0 {
+				return nil, "invalid token."
+			} else if ve.Errors&(jwt.ValidationErrorExpired|jwt.ValidationErrorNotValidYet) != 0 {
+				return nil, "token is either expired or not active yet"
+			}
+			return nil, fmt.Sprintf("error validating the token: %s", err)
+		}
+		return nil, fmt.Sprintf("invalid token: %s", err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, "invalid token."
+	}
+	return claims, ""
+}
+
+// VerifyClaims verifies the issuer, audience, authorized party, and presence of an expiration time
+//	as required for ID tokens (OpenID Connect Core 1.0, Section 3.1.3.7).
+//	When the claims are not valid, it returns the reason in status.
+func VerifyClaims(claims jwt.MapClaims, issuer, clientID string) (status string) {
+	if iss, _ := claims["iss"].(string); iss != issuer {
+		return fmt.Sprintf("token is issued by another provider: %s", iss)
+	}
+	audience := audience(claims)
+	if len(audience) == 0 {
+		return "token has no audience"
+	}
+	if !contains(audience, clientID) {
+		return fmt.Sprintf("token is issued for another client: %s", strings.Join(audience, ", "))
+	}
+	azp, hasAzp := claims["azp"].(string)
+	if len(audience) > 1 && !hasAzp {
+		return "token has multiple audiences but no authorized party"
+	}
+	if hasAzp && azp != clientID {
+		return fmt.Sprintf("token is authorized for another client: %s", azp)
+	}
+	if _, ok := claims["exp"]; !ok {
+		return "token has no expiration time"
+	}
+	return ""
+}
+
+// audience returns the aud claim, which is either a string or an array of strings
+func audience(claims jwt.MapClaims) []string {
+	switch aud := claims["aud"].(type) {
+	case string:
+		if aud != "" {
+			return []string{aud}
+		}
+	case []interface{}:
+		var audience []string
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				audience = append(audience, s)
+			}
+		}
+		return audience
+	}
+	return nil
+}
+
+// KeyFunc returns a jwt.Keyfunc that selects the verification key from the given keys by the key ID (kid)
+//	Only the given algorithms are accepted, or all supported Algorithms if none are given.
+//	The key must be of the type matching the algorithm.
+func KeyFunc(keys KeyProvider, algorithms []string) jwt.Keyfunc {
+	return KeyFuncContext(context.Background(), keys, algorithms)
+}
+
+// KeyFuncContext is like KeyFunc, with the context bounding key fetches of a ContextKeyProvider
+func KeyFuncContext(ctx context.Context, keys KeyProvider, algorithms []string) jwt.Keyfunc {
+	if len(algorithms) == 0 {
+		algorithms = Algorithms
+	}
+	return func(token *jwt.Token) (interface{}, error) {
+		if alg := token.Method.Alg(); !contains(Algorithms, alg) || !contains(algorithms, alg) {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		var key Key
+		var err error
+		if provider, ok := keys.(ContextKeyProvider); ok {
+			key, err = provider.KeyContext(ctx, kid)
+		} else {
+			key, err = keys.Key(kid)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if key.Algorithm != "" && key.Algorithm != token.Method.Alg() {
+			return nil, fmt.Errorf("signing method %s does not match the key algorithm %s", token.Method.Alg(), key.Algorithm)
+		}
+		if !matchesKeyType(token.Method, key.Key) {
+			return nil, fmt.Errorf("signing method %s does not match the key type", token.Method.Alg())
+		}
+		return key.Key, nil
+	}
+}
+
+// matchesKeyType checks whether the key is of the type used by the signing method
+func matchesKeyType(method jwt.SigningMethod, key crypto.PublicKey) bool {
+	switch m := method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		_, ok := key.(*rsa.PublicKey)
+		return ok
+	case *jwt.SigningMethodECDSA:
+		k, ok := key.(*ecdsa.PublicKey)
+		return ok && k.Curve.Params().BitSize == m.CurveBits
+	case *SigningMethodEd25519:
+		_, ok := key.(ed25519.PublicKey)
+		return ok
+	}
+	return false
+}
+
+// ValidateAlgorithms checks that the given algorithms are supported
+func ValidateAlgorithms(algorithms []string) error {
+	for _, alg := range algorithms {
+		if !contains(Algorithms, alg) {
+			return fmt.Errorf("unsupported signing algorithm: %s", alg)
+		}
+	}
+	return nil
+}
+
+func contains(slice []string, s string) bool {
+	for _, e := range slice {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -4,7 +4,9 @@
 package obtainer
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -15,28 +17,37 @@ import (
 )
 
 const (
-	TokenEndpoint = "/protocol/openid-connect/token"
-	DriverName    = "keycloak"
+	TokenEndpoint      = "/protocol/openid-connect/token"
+	RevocationEndpoint = "/protocol/openid-connect/revoke"
+	LogoutEndpoint     = "/protocol/openid-connect/logout"
+	DriverName         = "keycloak"
 )
 
-type KeycloakObtainer struct{}
+// KeycloakObtainer obtains tokens from a Keycloak realm
+//	It is safe for concurrent use.
+type KeycloakObtainer struct {
+	// Client is the HTTP client for requests to the realm, http.DefaultClient if nil
+	Client *http.Client
+}
 
 func init() {
 	// Register the driver as a auth/obtainer
-	obtainer.Register(DriverName, &KeycloakObtainer{})
-}
-
-type Token struct {
-	RefreshToken string `json:"refresh_token"`
-	IdToken      string `json:"id_token"`
+	obtainer.RegisterFactory(DriverName, func(conf obtainer.Conf) (obtainer.Driver, error) {
+		return &KeycloakObtainer{Client: conf.HTTPClient}, nil
+	})
 }
 
 // ObtainToken requests a token in exchange for user credentials.
 // This follows the OAuth 2.0 Resource Owner Password Credentials Grant.
 // For this flow, the client in Keycloak must have Direct Grant enabled.
-func (o *KeycloakObtainer) ObtainToken(serverAddr, username, password, clientID string) (token interface{}, err error) {
+func (o *KeycloakObtainer) ObtainToken(serverAddr, username, password, clientID string) (token *obtainer.Token, err error) {
+	return o.ObtainTokenContext(context.Background(), serverAddr, username, password, clientID)
+}
+
+// ObtainTokenContext is like ObtainToken, with the context bounding the request
+func (o *KeycloakObtainer) ObtainTokenContext(ctx context.Context, serverAddr, username, password, clientID string) (token *obtainer.Token, err error) {
 
-	res, err := http.PostForm(serverAddr+TokenEndpoint, url.Values{
+	req, err := newFormRequest(ctx, serverAddr+TokenEndpoint, url.Values{
 		"grant_type": {"password"},
 		"client_id":  {clientID},
 		"username":   {username},
@@ -46,47 +57,106 @@ func (o *KeycloakObtainer) ObtainToken(serverAddr, username, password, clientID
 	if err != nil {
 		return nil, err
 	}
-	defer res.Body.Close()
+	return o.requestToken(req, "error getting a token")
+}
 
-	body, err := ioutil.ReadAll(res.Body)
+// ObtainClientToken requests a token for the client in exchange for client credentials.
+// This follows the OAuth 2.0 Client Credentials Grant.
+// For this flow, the client in Keycloak must be confidential and have Service Accounts enabled.
+func (o *KeycloakObtainer) ObtainClientToken(serverAddr string, credentials obtainer.ClientCredentials) (token *obtainer.Token, err error) {
+	return o.ObtainClientTokenContext(context.Background(), serverAddr, credentials)
+}
+
+// ObtainClientTokenContext is like ObtainClientToken, with the context bounding the request
+func (o *KeycloakObtainer) ObtainClientTokenContext(ctx context.Context, serverAddr string, credentials obtainer.ClientCredentials) (token *obtainer.Token, err error) {
+
+	req, err := credentials.NewTokenRequest(serverAddr+TokenEndpoint, url.Values{
+		"grant_type": {"client_credentials"},
+		"scope":      {"openid"},
+	})
 	if err != nil {
 		return nil, err
 	}
-	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("error getting a token: %s", stringifyError(res.StatusCode, body))
+	return o.requestToken(req.WithContext(ctx), "error getting a token")
+}
+
+// RenewToken returns the token
+//  acquired either from the token object or by requesting a new one using refresh token
+//	The request is authenticated with the client credentials, which confidential clients require (RFC 6749, Section 6).
+func (o *KeycloakObtainer) RenewToken(serverAddr string, token *obtainer.Token, credentials obtainer.ClientCredentials) (newToken *obtainer.Token, err error) {
+	return o.RenewTokenContext(context.Background(), serverAddr, token, credentials)
+}
+
+// RenewTokenContext is like RenewToken, with the context bounding the request
+func (o *KeycloakObtainer) RenewTokenContext(ctx context.Context, serverAddr string, token *obtainer.Token, credentials obtainer.ClientCredentials) (newToken *obtainer.Token, err error) {
+	if token.RefreshToken == "" {
+		return nil, fmt.Errorf("token has no refresh token")
 	}
 
-	var keycloakToken Token
-	err = json.Unmarshal(body, &keycloakToken)
+	// get a new token using the refresh_token
+	req, err := credentials.NewTokenRequest(serverAddr+TokenEndpoint, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {token.RefreshToken},
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error decoding the token: %s", err)
+		return nil, err
 	}
+	return o.requestToken(req.WithContext(ctx), "error getting a new token")
+}
 
-	return keycloakToken, nil
+// RevokeToken revokes the token and ends the session
+//	The refresh token (or the access token, if there is no refresh token) is revoked following OAuth 2.0 Token
+//	Revocation (RFC 7009), which succeeds for tokens that are already revoked. Then, the session is ended at the
+//	logout endpoint using the refresh token. A session that is no longer active counts as ended, so that a failed
+//	revocation can be retried.
+func (o *KeycloakObtainer) RevokeToken(serverAddr string, token *obtainer.Token, credentials obtainer.ClientCredentials) error {
+	return o.RevokeTokenContext(context.Background(), serverAddr, token, credentials)
 }
 
-// TokenString returns the ID Token part of token object
-func (o *KeycloakObtainer) TokenString(token interface{}) (tokenString string, err error) {
-	if token, ok := token.(Token); ok {
-		return token.IdToken, nil
+// RevokeTokenContext is like RevokeToken, with the context bounding the requests
+func (o *KeycloakObtainer) RevokeTokenContext(ctx context.Context, serverAddr string, token *obtainer.Token, credentials obtainer.ClientCredentials) error {
+	form := url.Values{
+		"token":           {token.RefreshToken},
+		"token_type_hint": {"refresh_token"},
+	}
+	if token.RefreshToken == "" {
+		form = url.Values{
+			"token":           {token.AccessToken},
+			"token_type_hint": {"access_token"},
+		}
 	}
-	return "", fmt.Errorf("invalid input token: assertion error")
+	err := o.post(ctx, serverAddr+RevocationEndpoint, serverAddr+TokenEndpoint, credentials, form)
+	if err != nil {
+		return fmt.Errorf("error revoking the token: %s", err)
+	}
+
+	if token.RefreshToken != "" {
+		err := o.post(ctx, serverAddr+LogoutEndpoint, serverAddr+TokenEndpoint, credentials, url.Values{
+			"refresh_token": {token.RefreshToken},
+		})
+		// Keycloak rejects the refresh token of a session that is not active with invalid_grant
+		var providerErr *obtainer.ProviderError
+		if err != nil && !(errors.As(err, &providerErr) && providerErr.Code == "invalid_grant") {
+			return fmt.Errorf("error logging out: %s", err)
+		}
+	}
+	return nil
 }
 
-// RenewToken returns the token
-//  acquired either from the token object or by requesting a new one using refresh token
-func (o *KeycloakObtainer) RenewToken(serverAddr string, oldToken interface{}, clientID string) (newToken interface{}, err error) {
-	token, ok := oldToken.(Token)
-	if !ok {
-		return nil, fmt.Errorf("invalid input token: assertion error")
+// newFormRequest returns a POST request of the form
+func newFormRequest(ctx context.Context, endpoint string, form url.Values) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
 	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
 
-	// get a new token using the refresh_token
-	res, err := http.PostForm(serverAddr+TokenEndpoint, url.Values{
-		"grant_type":    {"refresh_token"},
-		"client_id":     {clientID},
-		"refresh_token": {token.RefreshToken},
-	})
+// requestToken sends the request to the token endpoint and decodes the token from the response
+//	The message describes the request in errors returned by the endpoint.
+func (o *KeycloakObtainer) requestToken(req *http.Request, message string) (*obtainer.Token, error) {
+	res, err := o.client().Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -97,24 +167,55 @@ func (o *KeycloakObtainer) RenewToken(serverAddr string, oldToken interface{}, c
 		return nil, err
 	}
 	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("error getting a new token: %s", stringifyError(res.StatusCode, body))
+		return nil, newProviderError(message+": ", res.StatusCode, body)
 	}
 
-	var keycloakToken Token
-	err = json.Unmarshal(body, &keycloakToken)
+	token, err := obtainer.NewTokenFromResponse(body)
 	if err != nil {
-		return nil, fmt.Errorf("error decoding the new token: %s", err)
+		return nil, fmt.Errorf("error decoding the token: %s", err)
 	}
-
-	return keycloakToken, nil
+	return token, nil
 }
 
-// Logout expires the ticket (Not applicable in the current flow)
-func (o *KeycloakObtainer) RevokeToken(serverAddr string, token interface{}) error {
-	// TODO https://www.keycloak.org/docs/latest/securing_apps/#_token_revocation_endpoint
+// post sends the form to the endpoint as the client, expecting a response without content
+func (o *KeycloakObtainer) post(ctx context.Context, endpoint, tokenEndpoint string, credentials obtainer.ClientCredentials, form url.Values) error {
+	req, err := credentials.NewEndpointRequest(endpoint, tokenEndpoint, form)
+	if err != nil {
+		return err
+	}
+	res, err := o.client().Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent {
+		body, _ := ioutil.ReadAll(res.Body)
+		return newProviderError("", res.StatusCode, body)
+	}
 	return nil
 }
 
+func (o *KeycloakObtainer) client() *http.Client {
+	if o.Client == nil {
+		return http.DefaultClient
+	}
+	return o.Client
+}
+
+// newProviderError returns the error of the response, with the message prefixed to its description
+func newProviderError(prefix string, status int, body []byte) *obtainer.ProviderError {
+	var response struct {
+		Error string `json:"error"`
+	}
+	json.Unmarshal(body, &response)
+	return &obtainer.ProviderError{
+		StatusCode: status,
+		Code:       response.Error,
+		Message:    prefix + stringifyError(status, body),
+	}
+}
+
 func stringifyError(status int, body []byte) string {
 	if len(body) == 0 {
 		return fmt.Sprintf("%d %s", status, http.StatusText(status))
@@ -0,0 +1,187 @@
+package obtainer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/linksmart/go-sec/auth/obtainer"
+)
+
+const (
+	testClientID     = "service"
+	testClientSecret = "secret"
+)
+
+func TestObtainClientToken(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating EC key: %s", err)
+	}
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		grantType := r.PostFormValue("grant_type")
+		if r.URL.Path != TokenEndpoint || grantType != "client_credentials" && grantType != "refresh_token" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// authenticate the client with any of the methods
+		authenticated := false
+		if id, secret, ok := r.BasicAuth(); ok {
+			authenticated = id == testClientID && secret == testClientSecret
+		} else if r.PostFormValue("client_secret") != "" {
+			authenticated = r.PostFormValue("client_id") == testClientID && r.PostFormValue("client_secret") == testClientSecret
+		} else if r.PostFormValue("client_assertion_type") == obtainer.ClientAssertionType {
+			var claims jwt.StandardClaims
+			_, err := jwt.ParseWithClaims(r.PostFormValue("client_assertion"), &claims, func(token *jwt.Token) (interface{}, error) {
+				return &key.PublicKey, nil
+			})
+			authenticated = err == nil && claims.Issuer == testClientID && claims.Subject == testClientID &&
+				claims.Audience == server.URL+TokenEndpoint && claims.Id != ""
+		}
+		if !authenticated {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "unauthorized_client"})
+			return
+		}
+		if grantType == "refresh_token" {
+			if r.PostFormValue("refresh_token") != "refresh" {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"access_token": "renewed", "refresh_token": "refresh"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "access", "refresh_token": "refresh"})
+	}))
+	defer server.Close()
+
+	for _, credentials := range []obtainer.ClientCredentials{
+		{ClientID: testClientID, ClientSecret: testClientSecret},
+		{ClientID: testClientID, ClientSecret: testClientSecret, AuthMethod: obtainer.ClientSecretPost},
+		{ClientID: testClientID, PrivateKey: key, AuthMethod: obtainer.PrivateKeyJWT},
+	} {
+		client, err := obtainer.NewClientCredentialsClient(DriverName, server.URL, credentials)
+		if err != nil {
+			t.Fatalf("Error creating client: %s", err)
+		}
+		tokenString, err := client.Obtain()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", credentials.AuthMethod, err)
+		}
+		if tokenString != "access" {
+			t.Fatalf("%s: unexpected token: %s", credentials.AuthMethod, tokenString)
+		}
+		// the refresh is authenticated like the grant
+		tokenString, err = client.Renew()
+		if err != nil || tokenString != "renewed" {
+			t.Fatalf("%s: unexpected renewal: %s %v", credentials.AuthMethod, tokenString, err)
+		}
+	}
+
+	client, _ := obtainer.NewClientCredentialsClient(DriverName, server.URL, obtainer.ClientCredentials{
+		ClientID: testClientID, ClientSecret: "wrong",
+	})
+	_, err = client.Obtain()
+	if err == nil {
+		t.Fatalf("Expected error for wrong client secret")
+	}
+	// a rejected client is not a rejected grant
+	var providerErr *obtainer.ProviderError
+	if !errors.As(err, &providerErr) || providerErr.Code != "unauthorized_client" || obtainer.IsRejected(err) {
+		t.Fatalf("Unexpected error: %#v", err)
+	}
+}
+
+func TestRevokeToken(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	failRevocation, failLogout := true, false
+	// sessions are ended at most once, like in Keycloak
+	ended := make(map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.PostFormValue("client_id") != "app" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case TokenEndpoint:
+			json.NewEncoder(w).Encode(map[string]interface{}{"id_token": "id", "refresh_token": "refresh"})
+		case LogoutEndpoint:
+			refreshToken := r.PostFormValue("refresh_token")
+			calls = append(calls, "logout "+refreshToken)
+			if ended[refreshToken] {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": "Session not active"})
+				return
+			}
+			ended[refreshToken] = true
+			if failLogout {
+				// the session is ended, but the response gets lost
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case RevocationEndpoint:
+			calls = append(calls, "revoke "+r.PostFormValue("token_type_hint")+" "+r.PostFormValue("token"))
+			if failRevocation {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "unsupported_token_type"})
+			}
+		}
+	}))
+	defer server.Close()
+
+	client, err := obtainer.NewClient(DriverName, server.URL, "john", "secret", "app")
+	if err != nil {
+		t.Fatalf("Error creating client: %s", err)
+	}
+	if _, err := client.Obtain(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expectCalls := func(expected ...string) {
+		t.Helper()
+		mu.Lock()
+		defer mu.Unlock()
+		if fmt.Sprint(calls) != fmt.Sprint(expected) {
+			t.Fatalf("Unexpected calls: %v, expected %v", calls, expected)
+		}
+		calls = nil
+	}
+
+	// errors of the provider are returned
+	if err := client.Revoke(); err == nil || !strings.Contains(err.Error(), "unsupported_token_type") {
+		t.Fatalf("Expected revocation error, got %v", err)
+	}
+	expectCalls("revoke refresh_token refresh")
+
+	mu.Lock()
+	failRevocation, failLogout = false, true
+	mu.Unlock()
+	if err := client.Revoke(); err == nil || !strings.Contains(err.Error(), "error logging out") {
+		t.Fatalf("Expected logout error, got %v", err)
+	}
+	expectCalls("revoke refresh_token refresh", "logout refresh")
+
+	// the retry succeeds although the session was already ended
+	if err := client.Revoke(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expectCalls("revoke refresh_token refresh", "logout refresh")
+	if client.Token() != nil {
+		t.Fatalf("Token kept after revocation")
+	}
+}
@@ -4,38 +4,70 @@
 package validator
 
 import (
-	"crypto/rsa"
-	"crypto/x509"
+	"context"
+	"crypto"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
+	"sync"
 
 	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/linksmart/go-sec/auth/jose"
 	"github.com/linksmart/go-sec/auth/validator"
 	"github.com/linksmart/go-sec/authz"
 )
 
-const DriverName = "keycloak"
+const (
+	DriverName    = "keycloak"
+	CertsEndpoint = "/protocol/openid-connect/certs"
+)
+
+// DefaultAlgorithms are the signing algorithms accepted when none are configured
+var DefaultAlgorithms = []string{"RS256", "RS384", "RS512"}
 
+// KeycloakValidator validates the tokens of a Keycloak realm
+//	It keeps the keys of the realm and is safe for concurrent use. Every Validator gets its own instance on Setup.
 type KeycloakValidator struct {
-	publicKey *rsa.PublicKey
+	// Algorithms are the accepted signing algorithms, DefaultAlgorithms if empty
+	Algorithms []string
+	// Client is the HTTP client for requests to the realm, http.DefaultClient if nil
+	Client *http.Client
+
+	mu         sync.Mutex
+	serverAddr string
+	keys       *jose.KeyCache
+}
+
+// NewKeycloakValidator returns a validator for the realm at the given server address
+//	The algorithms are the accepted signing algorithms, DefaultAlgorithms if empty.
+func NewKeycloakValidator(serverAddr string, algorithms []string) *KeycloakValidator {
+	v := &KeycloakValidator{Algorithms: algorithms}
+	v.realmKeys(serverAddr)
+	return v
 }
 
 func init() {
 	// Register the driver as a auth/validator
-	validator.Register(DriverName, &KeycloakValidator{})
+	validator.RegisterFactory(DriverName, func(conf validator.Conf) (validator.Driver, error) {
+		v := &KeycloakValidator{Algorithms: conf.Algorithms, Client: conf.HTTPClient}
+		v.realmKeys(conf.ProviderURL)
+		return v, nil
+	})
 }
 
 // Validate validates the token
 func (v *KeycloakValidator) Validate(serverAddr, clientID, tokenString string) (bool, *authz.Claims, error) {
+	return v.ValidateContext(context.Background(), serverAddr, clientID, tokenString)
+}
 
-	if v.publicKey == nil {
-		var err error
-		v.publicKey, err = queryPublicKey(serverAddr)
-		if err != nil {
-			return false, nil, fmt.Errorf("error querying public key: %s", err)
-		}
+// ValidateContext validates the token, with the context bounding the requests for the realm keys
+func (v *KeycloakValidator) ValidateContext(ctx context.Context, serverAddr, clientID, tokenString string) (bool, *authz.Claims, error) {
+
+	keys := v.realmKeys(serverAddr)
+	if _, err := keys.KeysContext(ctx); err != nil {
+		return false, nil, fmt.Errorf("error querying public key: %s", err)
 	}
 
 	type expectedClaims struct {
@@ -46,14 +78,12 @@ func (v *KeycloakValidator) Validate(serverAddr, clientID, tokenString string) (
 		Roles             []string `json:"roles"`
 		ClientID          string   `json:"clientID"` // for tokens issued as part of client credentials grant
 	}
-	// Parse the jwt id_token
-	token, err := jwt.ParseWithClaims(tokenString, &expectedClaims{}, func(token *jwt.Token) (interface{}, error) {
-		// Make sure that the algorithm is RS256
-		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-			return nil, fmt.Errorf("unable to validate authentication token: unexpected signing method: %v", token.Header["alg"])
-		}
-		return v.publicKey, nil
-	})
+	algorithms := v.Algorithms
+	if len(algorithms) == 0 {
+		algorithms = DefaultAlgorithms
+	}
+	// Parse the jwt id_token, selecting the key by its ID
+	token, err := jwt.ParseWithClaims(tokenString, &expectedClaims{}, jose.KeyFuncContext(ctx, keys, algorithms))
 	if err != nil {
 		// Check the validation errors
 		if token != nil && !token.Valid {
@@ -91,18 +121,85 @@ func (v *KeycloakValidator) Validate(serverAddr, clientID, tokenString string) (
 		return false, &authz.Claims{Status: fmt.Sprintf("token is issued by another provider: %s", claims.Issuer)}, nil
 	}
 
+	// keep all claims for authorization
+	extra, err := decodeClaims(token.Raw)
+	if err != nil {
+		return false, nil, fmt.Errorf("unable to decode claims of the jwt id_token: %s", err)
+	}
+
 	// return user profile from claims
 	return true, &authz.Claims{
 		Username: claims.PreferredUsername,
 		Groups:   claims.Groups,
 		Roles:    claims.Roles,
 		ClientID: claims.ClientID,
+		Extra:    extra,
 	}, nil
 }
 
-func queryPublicKey(serverAddr string) (*rsa.PublicKey, error) {
+// decodeClaims decodes the claims segment of a jwt into a map
+func decodeClaims(tokenString string) (map[string]interface{}, error) {
+	parts := strings.Split(tokenString, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("token contains an invalid number of segments")
+	}
+	b, err := jwt.DecodeSegment(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	var claims map[string]interface{}
+	err = json.Unmarshal(b, &claims)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
 
-	res, err := http.Get(serverAddr)
+// realmKeys returns the key cache of the realm
+//	The cache is created on first use and replaced if the validator is used for another realm.
+func (v *KeycloakValidator) realmKeys(serverAddr string) *jose.KeyCache {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.keys == nil || v.serverAddr != serverAddr {
+		v.serverAddr = serverAddr
+		client := v.Client
+		if client == nil {
+			client = http.DefaultClient
+		}
+		v.keys = jose.NewKeyCache(func(ctx context.Context) (*jose.KeySet, error) {
+			return fetchKeys(ctx, client, serverAddr)
+		})
+	}
+	return v.keys
+}
+
+// fetchKeys gets the realm keys from the certs endpoint
+//	If that fails, it falls back to the single public key given in the realm info.
+func fetchKeys(ctx context.Context, client *http.Client, serverAddr string) (*jose.KeySet, error) {
+	keys, err := jose.FetchKeySetWithClient(ctx, client, serverAddr+CertsEndpoint)
+	if err == nil {
+		return keys, nil
+	}
+	if ctx.Err() != nil {
+		return nil, err
+	}
+	publicKey, pkErr := queryPublicKey(ctx, client, serverAddr)
+	if pkErr != nil {
+		return nil, fmt.Errorf("%s; %s", err, pkErr)
+	}
+	return &jose.KeySet{Keys: []jose.Key{{Key: publicKey}}}, nil
+}
+
+// queryPublicKey gets the realm public key from the realm info
+//	RSA, ECDSA, and Ed25519 keys are supported.
+func queryPublicKey(ctx context.Context, client *http.Client, serverAddr string) (crypto.PublicKey, error) {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, serverAddr, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("error getting the public key from the authentication server: %s", err)
 	}
@@ -123,15 +220,10 @@ func queryPublicKey(serverAddr string) (*rsa.PublicKey, error) {
 	}
 
 	// Parse the public key
-	parsed, err := x509.ParsePKIXPublicKey(decoded)
+	publicKey, err := jose.ParsePKIXPublicKey(decoded)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing the authentication server public key: %s", err)
 	}
 
-	publicKey, ok := parsed.(*rsa.PublicKey)
-	if !ok {
-		return nil, fmt.Errorf("the authentication server's public key type is not RSA")
-	}
-
 	return publicKey, nil
 }
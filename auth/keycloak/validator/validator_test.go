@@ -0,0 +1,289 @@
+package validator
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/linksmart/go-sec/auth/httpclient"
+	"github.com/linksmart/go-sec/auth/jose"
+	"github.com/linksmart/go-sec/auth/validator"
+)
+
+const testClientID = "test-client"
+
+// testServer is a local Keycloak serving the keys of several realms
+//	All realms use the same key ID, so that mixing up their keys is detected.
+type testServer struct {
+	*httptest.Server
+	methods map[string]jwt.SigningMethod
+	keys    map[string]interface{}
+}
+
+// newTestServer creates a server with realms that sign tokens with the given methods
+func newTestServer(t *testing.T, realms map[string]jwt.SigningMethod) *testServer {
+	server := newUnstartedTestServer(t, realms)
+	server.Start()
+	return server
+}
+
+// newUnstartedTestServer is like newTestServer, without starting the server
+func newUnstartedTestServer(t *testing.T, realms map[string]jwt.SigningMethod) *testServer {
+	server := &testServer{methods: realms, keys: make(map[string]interface{})}
+	encode := func(b []byte) string {
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	mux := http.NewServeMux()
+	for realm, method := range realms {
+		var jwk map[string]string
+		switch method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+			key, err := rsa.GenerateKey(rand.Reader, 2048)
+			if err != nil {
+				t.Fatalf("Error generating RSA key: %s", err)
+			}
+			server.keys[realm] = key
+			jwk = map[string]string{"kty": "RSA", "n": encode(key.N.Bytes()), "e": encode(big.NewInt(int64(key.E)).Bytes())}
+		case *jwt.SigningMethodECDSA:
+			key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			if err != nil {
+				t.Fatalf("Error generating EC key: %s", err)
+			}
+			server.keys[realm] = key
+			jwk = map[string]string{"kty": "EC", "crv": "P-256", "x": encode(key.X.Bytes()), "y": encode(key.Y.Bytes())}
+		case *jose.SigningMethodEd25519:
+			public, key, err := ed25519.GenerateKey(rand.Reader)
+			if err != nil {
+				t.Fatalf("Error generating Ed25519 key: %s", err)
+			}
+			server.keys[realm] = key
+			jwk = map[string]string{"kty": "OKP", "crv": "Ed25519", "x": encode(public)}
+		}
+		jwk["kid"], jwk["alg"] = "k1", method.Alg()
+		mux.HandleFunc("/realms/"+realm+CertsEndpoint, func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{jwk}})
+		})
+	}
+	server.Server = httptest.NewUnstartedServer(mux)
+	return server
+}
+
+func (server *testServer) realmURL(realm string) string {
+	return server.URL + "/realms/" + realm
+}
+
+func (server *testServer) sign(t *testing.T, realm string) string {
+	token := jwt.NewWithClaims(server.methods[realm], jwt.MapClaims{
+		"iss":                server.realmURL(realm),
+		"aud":                testClientID,
+		"exp":                time.Now().Add(time.Minute).Unix(),
+		"preferred_username": "john",
+	})
+	token.Header["kid"] = "k1"
+	tokenString, err := token.SignedString(server.keys[realm])
+	if err != nil {
+		t.Fatalf("Error signing token: %s", err)
+	}
+	return tokenString
+}
+
+func TestValidateAlgorithms(t *testing.T) {
+	server := newTestServer(t, map[string]jwt.SigningMethod{
+		"rs256": jwt.SigningMethodRS256,
+		"ps256": jwt.SigningMethodPS256,
+		"es256": jwt.SigningMethodES256,
+		"eddsa": jose.SigningMethodEdDSA,
+	})
+	defer server.Close()
+
+	for _, tc := range []struct {
+		realm      string
+		algorithms []string
+		valid      bool
+	}{
+		{"rs256", nil, true},
+		{"ps256", nil, false},
+		{"es256", nil, false},
+		{"eddsa", nil, false},
+		{"ps256", []string{"PS256"}, true},
+		{"es256", []string{"ES256"}, true},
+		{"eddsa", []string{"EdDSA"}, true},
+		{"rs256", []string{"ES256"}, false},
+		{"es256", []string{"ES384"}, false},
+	} {
+		v := NewKeycloakValidator(server.realmURL(tc.realm), tc.algorithms)
+		valid, claims, err := v.Validate(server.realmURL(tc.realm), testClientID, server.sign(t, tc.realm))
+		if err != nil {
+			t.Fatalf("%s with %v: unexpected error: %s", tc.realm, tc.algorithms, err)
+		}
+		if valid != tc.valid {
+			t.Fatalf("%s with %v: expected valid=%t, got %t: %s", tc.realm, tc.algorithms, tc.valid, valid, claims.Status)
+		}
+	}
+}
+
+func TestValidateConcurrent(t *testing.T) {
+	realms := []string{"a", "b"}
+	server := newTestServer(t, map[string]jwt.SigningMethod{
+		"a": jwt.SigningMethodRS256,
+		"b": jwt.SigningMethodRS256,
+	})
+	defer server.Close()
+
+	handlers := make(map[string]http.Handler)
+	for _, realm := range realms {
+		v, err := validator.SetupFromConf(validator.Conf{
+			Enabled:     true,
+			Provider:    DriverName,
+			ProviderURL: server.realmURL(realm),
+			ClientID:    testClientID,
+		})
+		if err != nil {
+			t.Fatalf("Error setting up validator: %s", err)
+		}
+		handlers[realm] = v.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// the claims are passed on to the next handler
+			claims, ok := validator.ClaimsFromContext(r.Context())
+			if !ok || claims.Username != "john" {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprintf(w, "unexpected claims in context: %+v", claims)
+				return
+			}
+			if token, _ := validator.TokenFromContext(r.Context()); token != r.Header.Get("Authorization")[len("Bearer "):] {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprintf(w, "unexpected token in context: %s", token)
+			}
+		}))
+	}
+
+	tokens := make(map[string]string)
+	for _, realm := range realms {
+		tokens[realm] = server.sign(t, realm)
+	}
+
+	var wg sync.WaitGroup
+	errors := make(chan error, 200)
+	for i := 0; i < 50; i++ {
+		for _, realm := range realms {
+			for _, tokenRealm := range realms {
+				wg.Add(1)
+				go func(realm, tokenRealm string) {
+					defer wg.Done()
+					r := httptest.NewRequest(http.MethodGet, "/", nil)
+					r.Header.Set("Authorization", "Bearer "+tokens[tokenRealm])
+					w := httptest.NewRecorder()
+					handlers[realm].ServeHTTP(w, r)
+
+					expected := http.StatusUnauthorized
+					if realm == tokenRealm {
+						expected = http.StatusOK
+					}
+					if w.Code != expected {
+						errors <- fmt.Errorf("realm %s, token of realm %s: expected %d, got %d: %s", realm, tokenRealm, expected, w.Code, w.Body)
+					}
+				}(realm, tokenRealm)
+			}
+		}
+	}
+	wg.Wait()
+	close(errors)
+	for err := range errors {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateContext(t *testing.T) {
+	// a server that hangs until the test is done
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	v, err := validator.SetupFromConf(validator.Conf{
+		Enabled:     true,
+		Provider:    DriverName,
+		ProviderURL: server.URL,
+		ClientID:    testClientID,
+	})
+	if err != nil {
+		t.Fatalf("Error setting up validator: %s", err)
+	}
+	handler := v.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	r.Header.Set("Authorization", "Bearer token")
+	w := httptest.NewRecorder()
+
+	start := time.Now()
+	handler.ServeHTTP(w, r)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Request was not bounded by its context: took %s", elapsed)
+	}
+	if w.Code == http.StatusOK {
+		t.Fatalf("Expected an error, got %d", w.Code)
+	}
+}
+
+func TestValidateTLS(t *testing.T) {
+	server := newUnstartedTestServer(t, map[string]jwt.SigningMethod{"a": jwt.SigningMethodRS256})
+	server.StartTLS()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "keycloak")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	err = ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+	if err != nil {
+		t.Fatalf("Error writing CA file: %s", err)
+	}
+
+	for name, tc := range map[string]struct {
+		conf  validator.Conf
+		valid bool
+	}{
+		"default client": {validator.Conf{}, false},
+		"CA file":        {validator.Conf{Transport: &httpclient.Conf{CAFile: caFile}}, true},
+		"given client":   {validator.Conf{HTTPClient: server.Client()}, true},
+		"client over CA": {validator.Conf{HTTPClient: http.DefaultClient, Transport: &httpclient.Conf{CAFile: caFile}}, false},
+	} {
+		tc.conf.Provider, tc.conf.ProviderURL, tc.conf.ClientID = DriverName, server.realmURL("a"), testClientID
+		v, err := validator.SetupFromConf(tc.conf)
+		if err != nil {
+			t.Fatalf("%s: error setting up validator: %s", name, err)
+		}
+		valid, _, err := v.Validate(server.sign(t, "a"))
+		if tc.valid && (err != nil || !valid) {
+			t.Fatalf("%s: expected valid token, got valid=%t: %v", name, valid, err)
+		}
+		if !tc.valid && err == nil {
+			t.Fatalf("%s: expected an error fetching the keys", name)
+		}
+	}
+}
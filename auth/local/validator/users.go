@@ -0,0 +1,155 @@
+package validator
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// User is an entry of the users file
+type User struct {
+	Username string
+	// Hash is the bcrypt or argon2 hash of the password
+	Hash   string
+	Groups []string
+	Roles  []string
+}
+
+// ParseUsers parses a users file in htpasswd style, with groups and roles as additional fields
+//	Every line has the form username:hash[:groups[:roles]], where groups and roles are comma-separated lists.
+//	Empty lines and lines starting with # are ignored. The hashes must be bcrypt ($2a$, $2b$, $2y$) or argon2 in PHC
+//	string format ($argon2id$, $argon2i$).
+func ParseUsers(data []byte) (map[string]User, error) {
+	users := make(map[string]User)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < 2 || len(fields) > 4 {
+			return nil, fmt.Errorf("line %d: expected username:hash[:groups[:roles]]", n)
+		}
+		user := User{Username: fields[0], Hash: fields[1]}
+		if user.Username == "" {
+			return nil, fmt.Errorf("line %d: username is empty", n)
+		}
+		if _, found := users[user.Username]; found {
+			return nil, fmt.Errorf("line %d: duplicate user %s", n, user.Username)
+		}
+		if err := checkHash(user.Hash); err != nil {
+			return nil, fmt.Errorf("line %d: user %s: %s", n, user.Username, err)
+		}
+		if len(fields) > 2 {
+			user.Groups = splitList(fields[2])
+		}
+		if len(fields) > 3 {
+			user.Roles = splitList(fields[3])
+		}
+		users[user.Username] = user
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// splitList splits a comma-separated list, ignoring empty items
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// checkHash checks that the hash is in a supported format
+func checkHash(hash string) error {
+	switch {
+	case isBcrypt(hash):
+		_, err := bcrypt.Cost([]byte(hash))
+		return err
+	case strings.HasPrefix(hash, "$argon2"):
+		_, err := parseArgon2(hash)
+		return err
+	}
+	return errors.New("unsupported hash, use bcrypt or argon2")
+}
+
+// VerifyPassword checks the password against a bcrypt or argon2 hash
+func VerifyPassword(hash, password string) bool {
+	if isBcrypt(hash) {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+	params, err := parseArgon2(hash)
+	if err != nil {
+		return false
+	}
+	return params.verify(password)
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// argon2Hash is a hash in PHC string format, e.g. $argon2id$v=19$m=65536,t=3,p=4$salt$key
+type argon2Hash struct {
+	variant string
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func parseArgon2(hash string) (*argon2Hash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return nil, errors.New("invalid argon2 hash")
+	}
+	h := &argon2Hash{variant: parts[1]}
+	if h.variant != "argon2id" && h.variant != "argon2i" {
+		return nil, fmt.Errorf("unsupported argon2 variant: %s", h.variant)
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2 version: %s", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil {
+		return nil, fmt.Errorf("invalid argon2 parameters: %s", parts[3])
+	}
+	if h.time == 0 || h.threads == 0 {
+		return nil, fmt.Errorf("invalid argon2 parameters: %s", parts[3])
+	}
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, fmt.Errorf("invalid argon2 salt: %s", err)
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, fmt.Errorf("invalid argon2 key: %s", err)
+	}
+	if len(h.key) == 0 {
+		return nil, errors.New("invalid argon2 key: empty")
+	}
+	return h, nil
+}
+
+func (h *argon2Hash) verify(password string) bool {
+	var key []byte
+	if h.variant == "argon2id" {
+		key = argon2.IDKey([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+	} else {
+		key = argon2.Key([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+	}
+	return subtle.ConstantTimeCompare(key, h.key) == 1
+}
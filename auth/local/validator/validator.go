@@ -0,0 +1,147 @@
+// Copyright 2014-2016 Fraunhofer Institute for Applied Information Technology FIT
+
+// Package validator implements Basic Authentication against a local users file, without an identity provider
+//	The users file is in htpasswd style with bcrypt or argon2 hashes and assigns groups and roles to the users, so
+//	that the authorization rules can be used as with tokens of a provider.
+package validator
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/linksmart/go-sec/auth/validator"
+	"github.com/linksmart/go-sec/authz"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const DriverName = "local"
+
+// LocalValidator verifies Basic Authentication credentials against a users file
+//	The file is reloaded when it is modified. It is safe for concurrent use.
+type LocalValidator struct {
+	path string
+
+	mu       sync.Mutex
+	users    map[string]User
+	modified time.Time
+}
+
+// NewLocalValidator returns a validator for the users in the given file (see ParseUsers)
+func NewLocalValidator(path string) (*LocalValidator, error) {
+	v := &LocalValidator{path: path}
+	if err := v.load(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func init() {
+	// Register the driver as a auth/validator
+	validator.RegisterFactory(DriverName, func(conf validator.Conf) (validator.Driver, error) {
+		if !conf.BasicEnabled {
+			return nil, fmt.Errorf("basic authentication must be enabled to use the local users file")
+		}
+		return NewLocalValidator(strings.TrimPrefix(conf.ProviderURL, "file://"))
+	})
+}
+
+// Validate rejects the token, as there is no provider issuing tokens
+func (v *LocalValidator) Validate(serverAddr, clientID, tokenString string) (bool, *authz.Claims, error) {
+	return false, &authz.Claims{Status: "tokens are not supported by the local users file, use basic authentication"}, nil
+}
+
+// ValidateContext rejects the token, as there is no provider issuing tokens
+func (v *LocalValidator) ValidateContext(ctx context.Context, serverAddr, clientID, tokenString string) (bool, *authz.Claims, error) {
+	return v.Validate(serverAddr, clientID, tokenString)
+}
+
+// ValidateBasic verifies the credentials against the users file and returns the groups and roles of the user
+func (v *LocalValidator) ValidateBasic(ctx context.Context, username, password string) (bool, *authz.Claims, error) {
+	v.reload()
+
+	v.mu.Lock()
+	user, found := v.users[username]
+	v.mu.Unlock()
+
+	if !found {
+		// take the same time as for a wrong password, so that users cannot be probed
+		VerifyPassword(dummyHash(), password)
+		return false, &authz.Claims{Status: "invalid username or password"}, nil
+	}
+	if !VerifyPassword(user.Hash, password) {
+		return false, &authz.Claims{Status: "invalid username or password"}, nil
+	}
+
+	return true, &authz.Claims{
+		Username: user.Username,
+		Groups:   user.Groups,
+		Roles:    user.Roles,
+	}, nil
+}
+
+// load reads the users file
+func (v *LocalValidator) load() error {
+	info, err := os.Stat(v.path)
+	if err != nil {
+		return fmt.Errorf("error reading users file: %s", err)
+	}
+	data, err := ioutil.ReadFile(v.path)
+	if err != nil {
+		return fmt.Errorf("error reading users file: %s", err)
+	}
+	users, err := ParseUsers(data)
+	if err != nil {
+		return fmt.Errorf("error parsing users file %s: %s", v.path, err)
+	}
+
+	v.mu.Lock()
+	v.users, v.modified = users, info.ModTime()
+	v.mu.Unlock()
+	return nil
+}
+
+// reload reads the users file if it was modified
+//	If the modified file cannot be read, the previous users remain in use.
+func (v *LocalValidator) reload() {
+	info, err := os.Stat(v.path)
+	if err != nil {
+		log.Printf("go-sec/validator: error checking users file: %s", err)
+		return
+	}
+	v.mu.Lock()
+	modified := !info.ModTime().Equal(v.modified)
+	v.mu.Unlock()
+	if !modified {
+		return
+	}
+	if err := v.load(); err != nil {
+		log.Printf("go-sec/validator: error reloading users file, keeping the previous users: %s", err)
+		v.mu.Lock()
+		v.modified = info.ModTime()
+		v.mu.Unlock()
+	}
+}
+
+var (
+	dummyOnce sync.Once
+	dummy     string
+)
+
+// dummyHash returns a bcrypt hash of a random password, to verify passwords of unknown users against
+func dummyHash() string {
+	dummyOnce.Do(func() {
+		password := make([]byte, 16)
+		rand.Read(password)
+		hash, _ := bcrypt.GenerateFromPassword([]byte(hex.EncodeToString(password)), bcrypt.DefaultCost)
+		dummy = string(hash)
+	})
+	return dummy
+}
@@ -0,0 +1,154 @@
+package validator
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/linksmart/go-sec/auth/validator"
+	"github.com/linksmart/go-sec/authz"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func hashBcrypt(t *testing.T, password string) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Error hashing password: %s", err)
+	}
+	return string(hash)
+}
+
+func hashArgon2(password string) string {
+	salt := []byte("saltsaltsaltsalt")
+	key := argon2.IDKey([]byte(password), salt, 1, 1024, 1, 32)
+	return fmt.Sprintf("$argon2id$v=19$m=1024,t=1,p=1$%s$%s",
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func TestHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-sec")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	usersFile := filepath.Join(dir, "users")
+	users := fmt.Sprintf("# username:hash:groups:roles\n"+
+		"alice:%s:admin,dev:editor\n"+
+		"bob:%s::viewer\n",
+		hashBcrypt(t, "alice-secret"), hashArgon2("bob-secret"))
+	if err := ioutil.WriteFile(usersFile, []byte(users), 0600); err != nil {
+		t.Fatalf("Error writing users file: %s", err)
+	}
+
+	v, err := validator.SetupFromConf(validator.Conf{
+		Enabled:      true,
+		Provider:     DriverName,
+		ProviderURL:  usersFile,
+		ClientID:     "test-client",
+		BasicEnabled: true,
+		Authz: authz.Conf{
+			Enabled: true,
+			Rules: []authz.Rule{
+				{Paths: []string{"/data"}, Methods: []string{"GET"}, Roles: []string{"editor", "viewer"}},
+				{Paths: []string{"/admin"}, Methods: []string{"GET"}, Groups: []string{"admin"}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error setting up validator: %s", err)
+	}
+	handler := v.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := validator.ClaimsFromContext(r.Context())
+		fmt.Fprint(w, claims.Username)
+	}))
+
+	request := func(path, username, password string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.SetBasicAuth(username, password)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, tc := range []struct {
+		name     string
+		path     string
+		username string
+		password string
+		code     int
+	}{
+		{"bcrypt", "/data", "alice", "alice-secret", http.StatusOK},
+		{"argon2", "/data", "bob", "bob-secret", http.StatusOK},
+		{"group", "/admin", "alice", "alice-secret", http.StatusOK},
+		{"forbidden", "/admin", "bob", "bob-secret", http.StatusForbidden},
+		{"wrong password", "/data", "alice", "bob-secret", http.StatusUnauthorized},
+		{"unknown user", "/data", "carol", "carol-secret", http.StatusUnauthorized},
+	} {
+		w := request(tc.path, tc.username, tc.password)
+		if w.Code != tc.code {
+			t.Fatalf("%s: expected %d, got %d: %s", tc.name, tc.code, w.Code, w.Body)
+		}
+		if w.Code == http.StatusOK && w.Body.String() != tc.username {
+			t.Fatalf("%s: expected user %s in context, got %s", tc.name, tc.username, w.Body)
+		}
+	}
+
+	// bearer tokens are rejected
+	r := httptest.NewRequest(http.MethodGet, "/data", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("bearer: expected %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	// the users file is reloaded when modified
+	users += fmt.Sprintf("carol:%s::viewer\n", hashBcrypt(t, "carol-secret"))
+	if err := ioutil.WriteFile(usersFile, []byte(users), 0600); err != nil {
+		t.Fatalf("Error writing users file: %s", err)
+	}
+	modified := time.Now().Add(time.Second)
+	if err := os.Chtimes(usersFile, modified, modified); err != nil {
+		t.Fatalf("Error touching users file: %s", err)
+	}
+	if w := request("/data", "carol", "carol-secret"); w.Code != http.StatusOK {
+		t.Fatalf("reloaded user: expected %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+}
+
+func TestParseUsers(t *testing.T) {
+	hash := hashBcrypt(t, "secret")
+	for _, tc := range []struct {
+		name  string
+		data  string
+		valid bool
+	}{
+		{"groups and roles", "alice:" + hash + ":a, b:c\n", true},
+		{"comments", "# users\n\nalice:" + hash + "\n", true},
+		{"argon2i", "alice:$argon2i$v=19$m=1024,t=1,p=1$c2FsdA$a2V5\n", true},
+		{"plain password", "alice:secret\n", false},
+		{"md5", "alice:$apr1$salt$hash\n", false},
+		{"argon2 version", "alice:$argon2id$v=16$m=1024,t=1,p=1$c2FsdA$a2V5\n", false},
+		{"duplicate", "alice:" + hash + "\nalice:" + hash + "\n", false},
+		{"no hash", "alice\n", false},
+		{"too many fields", "alice:" + hash + ":a:b:c\n", false},
+	} {
+		_, err := ParseUsers([]byte(tc.data))
+		if (err == nil) != tc.valid {
+			t.Errorf("%s: expected valid=%t, got error: %v", tc.name, tc.valid, err)
+		}
+	}
+
+	users, _ := ParseUsers([]byte("alice:" + hash + ":a, b:c\n"))
+	if user := users["alice"]; len(user.Groups) != 2 || user.Groups[1] != "b" || len(user.Roles) != 1 || user.Roles[0] != "c" {
+		t.Fatalf("Unexpected groups and roles: %+v", user)
+	}
+}
@@ -3,15 +3,45 @@
 package obtainer
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
 	"sync"
+	"time"
 )
 
+// DefaultRefreshMargin is how long before its expiry a token is refreshed
+const DefaultRefreshMargin = 30 * time.Second
+
+// backgroundRetryInterval is the wait after a failed background refresh
+const backgroundRetryInterval = 10 * time.Second
+
+// backgroundTimeout bounds the requests of a background refresh
+const backgroundTimeout = time.Minute
+
 type Client struct {
+	// RefreshMargin is how long before its expiry the token is refreshed, DefaultRefreshMargin if zero
+	//	For short-lived tokens, it is reduced to half of the token lifetime.
+	RefreshMargin time.Duration
+	// Use selects the token string returned by Obtain and Renew: UseIDToken or UseAccessToken
+	//	If empty, the ID token is returned if available and the access token otherwise.
+	Use string
+
 	obtainer *Obtainer
 	username string
 	password string
 	clientID string
-	token    interface{}
+	// credentials are set for clients using the client credentials grant
+	credentials *ClientCredentials
+	token       *Token
+	// obtained is the time the token was obtained or renewed
+	obtained time.Time
+	// updating serializes the updates of the token, which are done without holding the lock
+	updating chan struct{}
+	// stop stops the background refresh, if running
+	stop chan struct{}
 	sync.Mutex
 }
 
@@ -21,56 +51,360 @@ func NewClient(providerName, providerURL, username, password, clientID string) (
 	if err != nil {
 		return nil, err
 	}
+	return newPasswordClient(o, username, password, clientID), nil
+}
 
+// NewClientCredentialsClient returns a client that obtains tokens for itself using the client credentials grant
+func NewClientCredentialsClient(providerName, providerURL string, credentials ClientCredentials) (*Client, error) {
+	if err := credentials.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid client credentials: %s", err)
+	}
+	// Setup obtainer
+	o, err := Setup(providerName, providerURL)
+	if err != nil {
+		return nil, err
+	}
+	return newCredentialsClient(o, credentials), nil
+}
+
+// NewClientFromConf returns a client for the grant type given in the configuration
+func NewClientFromConf(conf Conf) (*Client, error) {
+	client, err := newClientFromConf(conf)
+	if err != nil {
+		return nil, err
+	}
+	client.Use = conf.TokenUse
+	return client, nil
+}
+
+func newClientFromConf(conf Conf) (*Client, error) {
+	var credentials ClientCredentials
+	if conf.GrantType == GrantClientCredentials {
+		credentials = ClientCredentials{
+			ClientID:     conf.ClientID,
+			AuthMethod:   conf.ClientAuthMethod,
+			ClientSecret: conf.ClientSecret,
+			KeyID:        conf.KeyID,
+		}
+		if conf.PrivateKeyFile != "" {
+			key, err := LoadPrivateKey(conf.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			credentials.PrivateKey = key
+		}
+		if err := credentials.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid client credentials: %s", err)
+		}
+	}
+
+	// Setup obtainer
+	o, err := SetupFromConf(conf)
+	if err != nil {
+		return nil, err
+	}
+	if conf.GrantType == GrantClientCredentials {
+		return newCredentialsClient(o, credentials), nil
+	}
+	return newPasswordClient(o, conf.Username, conf.Password, conf.ClientID), nil
+}
+
+func newPasswordClient(o *Obtainer, username, password, clientID string) *Client {
 	return &Client{
 		obtainer: o,
 		username: username,
 		password: password,
 		clientID: clientID,
-	}, nil
+		updating: make(chan struct{}, 1),
+	}
+}
+
+func newCredentialsClient(o *Obtainer, credentials ClientCredentials) *Client {
+	return &Client{
+		obtainer:    o,
+		clientID:    credentials.ClientID,
+		credentials: &credentials,
+		updating:    make(chan struct{}, 1),
+	}
 }
 
 // Obtain obtains a new token and returns the token string. If token is already available, it just returns the token string
+//	A token that is about to expire is refreshed first.
 func (c *Client) Obtain() (tokenString string, err error) {
+	return c.ObtainContext(context.Background())
+}
+
+// ObtainContext is like Obtain, with the context bounding the requests to the provider
+//	If the refresh of a token that is about to expire fails, the token is returned as long as it is not expired.
+func (c *Client) ObtainContext(ctx context.Context) (tokenString string, err error) {
 	c.Lock()
-	defer c.Unlock()
+	if c.token != nil && !c.expiresSoon() {
+		defer c.Unlock()
+		return c.token.TokenString(c.Use)
+	}
+	c.Unlock()
 
-	if c.token == nil {
-		token, err := c.obtainer.ObtainToken(c.username, c.password, c.clientID)
-		if err != nil {
-			return "", err
-		}
-		c.token = token
+	tokenString, err = c.update(ctx, func() bool {
+		return c.token == nil || c.expiresSoon()
+	})
+	if err == nil {
+		return tokenString, nil
 	}
-	return c.obtainer.TokenString(c.token)
+
+	// fall back to the current token, which may have been revoked meanwhile
+	c.Lock()
+	defer c.Unlock()
+	if c.token == nil || c.expired() {
+		return "", err
+	}
+	return c.token.TokenString(c.Use)
 }
 
 // Renew renews the token and returns the token string
+//	If the token cannot be renewed, e.g. because the refresh token is expired, a new token is obtained.
 func (c *Client) Renew() (tokenString string, err error) {
+	return c.RenewContext(context.Background())
+}
+
+// RenewContext is like Renew, with the context bounding the requests to the provider
+func (c *Client) RenewContext(ctx context.Context) (tokenString string, err error) {
+	return c.update(ctx, func() bool {
+		return true
+	})
+}
+
+// Token returns a deep copy of the current token, or nil if no token was obtained yet
+//	It gives access to all parts of the token, e.g. to send the access token in addition to the ID token.
+func (c *Client) Token() *Token {
 	c.Lock()
+	defer c.Unlock()
+
+	return c.token.copy()
+}
+
+// renewRejected renews the token after it was rejected, unless it was already replaced
+//	This way, concurrent requests rejected with the same token lead to a single renewal.
+func (c *Client) renewRejected(ctx context.Context, rejected string) (tokenString string, err error) {
+	return c.update(ctx, func() bool {
+		if c.token == nil {
+			return true
+		}
+		current, err := c.token.TokenString(c.Use)
+		return err != nil || current == rejected
+	})
+}
+
+// Revoke revokes the token at the provider and ends the session
+//	The token is kept when the revocation fails, so that it can be retried.
+func (c *Client) Revoke() error {
+	return c.RevokeContext(context.Background())
+}
+
+// RevokeContext is like Revoke, with the context bounding the requests to the provider
+func (c *Client) RevokeContext(ctx context.Context) error {
+	select {
+	case c.updating <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-c.updating }()
+
+	c.Lock()
+	token := c.token
+	c.Unlock()
+	if token == nil {
+		return nil
+	}
 
-	token, err := c.obtainer.RenewToken(c.token, c.clientID)
+	err := c.obtainer.RevokeTokenContext(ctx, token, c.clientCredentials())
 	if err != nil {
-		// could not renew, try to obtain a new one
-		c.Unlock()
-		return c.Obtain() // this acquires the lock on its own
+		return err
 	}
-	c.token = token
 
+	c.Lock()
+	c.setToken(nil)
+	c.Unlock()
+	return nil
+}
+
+// StartBackgroundRefresh refreshes the token in a background goroutine ahead of its expiry
+//	The refreshes are advanced by a random jitter of up to half the refresh margin, to spread the load of many
+//	clients on the provider. Failed refreshes are retried until the client is stopped.
+func (c *Client) StartBackgroundRefresh() {
+	c.Lock()
 	defer c.Unlock()
-	return c.obtainer.TokenString(c.token)
+
+	if c.stop != nil {
+		return
+	}
+	c.stop = make(chan struct{})
+	go c.refreshLoop(c.stop)
 }
 
-// Revoke revokes the token
-func (c *Client) Revoke() error {
+// StopBackgroundRefresh stops the background refresh
+func (c *Client) StopBackgroundRefresh() {
 	c.Lock()
 	defer c.Unlock()
 
-	err := c.obtainer.RevokeToken(c.token)
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+}
+
+func (c *Client) refreshLoop(stop chan struct{}) {
+	// cancel requests when stopped
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	wait := c.nextRefresh()
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		refreshCtx, cancelRefresh := context.WithTimeout(ctx, backgroundTimeout)
+		_, err := c.update(refreshCtx, func() bool {
+			return c.token == nil || c.expiresSoon()
+		})
+		cancelRefresh()
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			log.Printf("go-sec/obtainer: error refreshing token in the background: %s", err)
+			wait = backgroundRetryInterval
+			continue
+		}
+		wait = c.nextRefresh()
+	}
+}
+
+// nextRefresh returns the wait until the next background refresh, including jitter
+func (c *Client) nextRefresh() time.Duration {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.token == nil {
+		return 0
+	}
+	margin := c.margin()
+	if c.token.Expiry.IsZero() {
+		// nothing to refresh, check again later as the token may be replaced
+		return margin
+	}
+	wait := time.Until(c.token.Expiry.Add(-margin))
+	if jitter := int64(margin / 2); jitter > 0 {
+		wait -= time.Duration(rand.Int63n(jitter))
+	}
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// update replaces the token if still needed once it is this caller's turn, and returns the token string
+//	Only one update runs at a time and waiting for it is bounded by the context. The client is not locked during the
+//	requests to the provider, so that the current token remains available meanwhile. The token string is taken
+//	before giving up the turn, as a revocation may remove the token right after.
+func (c *Client) update(ctx context.Context, needed func() bool) (tokenString string, err error) {
+	select {
+	case c.updating <- struct{}{}:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	defer func() { <-c.updating }()
+
+	c.Lock()
+	if !needed() {
+		defer c.Unlock()
+		return c.tokenString()
+	}
+	current := c.token
+	c.Unlock()
+
+	token, err := c.fetch(ctx, current)
 	if err != nil {
-		return err
+		return "", err
 	}
-	c.token = nil
 
-	return nil
+	c.Lock()
+	defer c.Unlock()
+	c.setToken(token)
+	return c.tokenString()
+}
+
+// tokenString returns the token string of the current token
+func (c *Client) tokenString() (string, error) {
+	if c.token == nil {
+		return "", errors.New("no token available")
+	}
+	return c.token.TokenString(c.Use)
+}
+
+// fetch renews the token using the refresh token, or obtains a new one if that is not possible
+func (c *Client) fetch(ctx context.Context, current *Token) (*Token, error) {
+	if current != nil && (current.RefreshExpiry.IsZero() || time.Now().Before(current.RefreshExpiry)) {
+		token, err := c.obtainer.RenewTokenContext(ctx, current, c.clientCredentials())
+		if err == nil {
+			return token, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	// could not renew, try to obtain a new one
+	if c.credentials != nil {
+		return c.obtainer.ObtainClientTokenContext(ctx, *c.credentials)
+	}
+	return c.obtainer.ObtainTokenContext(ctx, c.username, c.password, c.clientID)
+}
+
+// clientCredentials returns the credentials to authenticate as the client, only the client ID for public clients
+func (c *Client) clientCredentials() ClientCredentials {
+	if c.credentials != nil {
+		return *c.credentials
+	}
+	return ClientCredentials{ClientID: c.clientID, AuthMethod: ClientAuthNone}
+}
+
+func (c *Client) setToken(token *Token) {
+	c.token = token
+	c.obtained = time.Now()
+}
+
+// expired checks whether the token is expired
+func (c *Client) expired() bool {
+	return !c.token.Expiry.IsZero() && !time.Now().Before(c.token.Expiry)
+}
+
+// expiresSoon checks whether the token is within the refresh margin of its expiry
+func (c *Client) expiresSoon() bool {
+	return !c.token.Expiry.IsZero() && !time.Now().Before(c.token.Expiry.Add(-c.margin()))
+}
+
+// margin returns the refresh margin, at most half of the token lifetime
+func (c *Client) margin() time.Duration {
+	margin := c.RefreshMargin
+	if margin == 0 {
+		margin = DefaultRefreshMargin
+	}
+	if c.token != nil && !c.token.Expiry.IsZero() {
+		if half := c.token.Expiry.Sub(c.obtained) / 2; half < margin {
+			margin = half
+		}
+		if margin < 0 {
+			margin = 0
+		}
+	}
+	return margin
 }
@@ -0,0 +1,167 @@
+package obtainer
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDriver issues tokens with fixed lifetimes and counts the requests
+type fakeDriver struct {
+	sync.Mutex
+	lifetime        time.Duration
+	refreshLifetime time.Duration
+	obtained        int
+	renewed         int
+}
+
+func (d *fakeDriver) newToken(kind string, n int) *Token {
+	now := time.Now()
+	return &Token{
+		AccessToken:   fmt.Sprintf("%s-%d", kind, n),
+		RefreshToken:  "refresh",
+		Expiry:        now.Add(d.lifetime),
+		RefreshExpiry: now.Add(d.refreshLifetime),
+	}
+}
+
+func (d *fakeDriver) ObtainToken(serverAddr, username, password, clientID string) (*Token, error) {
+	d.Lock()
+	defer d.Unlock()
+	d.obtained++
+	return d.newToken("obtained", d.obtained), nil
+}
+
+func (d *fakeDriver) ObtainClientToken(serverAddr string, credentials ClientCredentials) (*Token, error) {
+	return d.ObtainToken(serverAddr, "", "", credentials.ClientID)
+}
+
+func (d *fakeDriver) RenewToken(serverAddr string, token *Token, credentials ClientCredentials) (*Token, error) {
+	d.Lock()
+	defer d.Unlock()
+	if time.Now().After(token.RefreshExpiry) {
+		return nil, errors.New("refresh token expired")
+	}
+	d.renewed++
+	return d.newToken("renewed", d.renewed), nil
+}
+
+func (d *fakeDriver) RevokeToken(serverAddr string, token *Token, credentials ClientCredentials) error {
+	return nil
+}
+
+func (d *fakeDriver) counts() (obtained, renewed int) {
+	d.Lock()
+	defer d.Unlock()
+	return d.obtained, d.renewed
+}
+
+func newFakeClient(t *testing.T, name string, driver *fakeDriver) *Client {
+	Register(name, driver)
+	client, err := NewClient(name, "http://localhost", "john", "secret", "test-client")
+	if err != nil {
+		t.Fatalf("Error creating client: %s", err)
+	}
+	return client
+}
+
+func TestClientRefresh(t *testing.T) {
+	driver := &fakeDriver{lifetime: 200 * time.Millisecond, refreshLifetime: 400 * time.Millisecond}
+	client := newFakeClient(t, "fake-refresh", driver)
+
+	obtain := func(expected string) {
+		t.Helper()
+		tokenString, err := client.Obtain()
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if tokenString != expected {
+			t.Fatalf("Expected token %s, got %s", expected, tokenString)
+		}
+	}
+
+	obtain("obtained-1")
+	obtain("obtained-1")
+
+	// refreshed within the margin, i.e. half of the lifetime
+	time.Sleep(120 * time.Millisecond)
+	obtain("renewed-1")
+
+	// obtained again when the refresh token is expired
+	time.Sleep(450 * time.Millisecond)
+	obtain("obtained-2")
+
+	// explicit renewal
+	if tokenString, err := client.Renew(); err != nil || tokenString != "renewed-2" {
+		t.Fatalf("Unexpected renewal: %s %v", tokenString, err)
+	}
+}
+
+func TestClientBackgroundRefresh(t *testing.T) {
+	driver := &fakeDriver{lifetime: 100 * time.Millisecond, refreshLifetime: time.Hour}
+	client := newFakeClient(t, "fake-background", driver)
+
+	client.StartBackgroundRefresh()
+	defer client.StopBackgroundRefresh()
+
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		_, renewed := driver.counts()
+		if renewed >= 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected background refreshes, got %d", renewed)
+		}
+	}
+	client.StopBackgroundRefresh()
+
+	// the background refresh keeps the token fresh, so that it is not refreshed on use
+	if obtained, _ := driver.counts(); obtained != 1 {
+		t.Fatalf("Expected a single obtain, got %d", obtained)
+	}
+	_, before := driver.counts()
+	time.Sleep(200 * time.Millisecond)
+	if _, after := driver.counts(); after != before {
+		t.Fatalf("Token refreshed after stop")
+	}
+}
+
+func TestClientConcurrentRevoke(t *testing.T) {
+	driver := &fakeDriver{lifetime: time.Hour, refreshLifetime: time.Hour}
+	client := newFakeClient(t, "fake-revoke", driver)
+	// interleave the goroutines even on a single CPU
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(8))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 2000; j++ {
+				if _, err := client.Obtain(); err != nil {
+					t.Errorf("Unexpected error obtaining: %s", err)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 2000; j++ {
+				if _, err := client.Renew(); err != nil {
+					t.Errorf("Unexpected error renewing: %s", err)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 2000; j++ {
+				if err := client.Revoke(); err != nil {
+					t.Errorf("Unexpected error revoking: %s", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -2,7 +2,10 @@ package obtainer
 
 import (
 	"errors"
+	"net/http"
 	"net/url"
+
+	"github.com/linksmart/go-sec/auth/httpclient"
 )
 
 // Conf is a reference configuration struct for Obtainer
@@ -15,10 +18,28 @@ type Conf struct {
 	ProviderURL string `json:"providerURL"`
 	// ClientID is the authentication client id.
 	ClientID string `json:"clientID"`
-	// Username is the client's username
+	// GrantType is either password (default) or client_credentials
+	GrantType string `json:"grantType"`
+	// Username is the client's username, for the password grant
 	Username string `json:"username"`
-	// Password is the client's password
+	// Password is the client's password, for the password grant
 	Password string `json:"password"`
+	// ClientAuthMethod is client_secret_basic (default), client_secret_post, or private_key_jwt, for the client credentials grant
+	ClientAuthMethod string `json:"clientAuthMethod"`
+	// ClientSecret is the client secret, for client_secret_basic and client_secret_post
+	ClientSecret string `json:"clientSecret"`
+	// PrivateKeyFile is the path of the PEM encoded private key, for private_key_jwt
+	PrivateKeyFile string `json:"privateKeyFile"`
+	// KeyID is the ID of the private key, for private_key_jwt (optional)
+	KeyID string `json:"keyID"`
+	// TokenUse is the token to send to services: id_token or access_token (optional)
+	//	By default, the ID token is sent if available and the access token otherwise.
+	TokenUse string `json:"tokenUse"`
+	// Transport configures the HTTP client for requests to the provider, e.g. to trust a private CA (optional)
+	Transport *httpclient.Conf `json:"transport"`
+	// HTTPClient is the HTTP client for requests to the provider (optional)
+	//	It takes precedence over Transport. If neither is set, http.DefaultClient is used.
+	HTTPClient *http.Client `json:"-"`
 }
 
 // Validate validates the configuration object
@@ -38,9 +59,34 @@ func (c Conf) Validate() error {
 		return errors.New("auth provider URL is invalid: " + err.Error())
 	}
 
-	// Validate Username
-	if c.Username == "" {
-		return errors.New("auth username is not specified")
+	// Validate credentials of the grant
+	switch c.GrantType {
+	case "", GrantPassword:
+		if c.Username == "" {
+			return errors.New("auth username is not specified")
+		}
+	case GrantClientCredentials:
+		switch c.ClientAuthMethod {
+		case "", ClientSecretBasic, ClientSecretPost:
+			if c.ClientSecret == "" {
+				return errors.New("auth client secret is not specified")
+			}
+		case PrivateKeyJWT:
+			if c.PrivateKeyFile == "" {
+				return errors.New("auth private key file is not specified")
+			}
+		default:
+			return errors.New("auth client authentication method is not supported: " + c.ClientAuthMethod)
+		}
+	default:
+		return errors.New("auth grant type is not supported: " + c.GrantType)
+	}
+
+	// Validate TokenUse
+	switch c.TokenUse {
+	case "", UseIDToken, UseAccessToken:
+	default:
+		return errors.New("auth token use is not supported: " + c.TokenUse)
 	}
 
 	// Validate ClientID
@@ -48,5 +94,12 @@ func (c Conf) Validate() error {
 		return errors.New("auth client ID is not specified")
 	}
 
+	// Validate Transport
+	if c.Transport != nil {
+		if err := c.Transport.Validate(); err != nil {
+			return errors.New("auth transport: " + err.Error())
+		}
+	}
+
 	return nil
 }
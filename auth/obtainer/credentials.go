@@ -0,0 +1,202 @@
+package obtainer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/linksmart/go-sec/auth/jose"
+)
+
+// Client authentication methods (OpenID Connect Core 1.0, Section 9)
+const (
+	ClientAuthNone    = "none"
+	ClientSecretBasic = "client_secret_basic"
+	ClientSecretPost  = "client_secret_post"
+	PrivateKeyJWT     = "private_key_jwt"
+)
+
+// Grant types
+const (
+	GrantPassword          = "password"
+	GrantClientCredentials = "client_credentials"
+)
+
+// ClientAssertionType is the type of client assertions for private_key_jwt authentication (RFC 7523)
+const ClientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// clientAssertionLifetime is the validity of client assertions
+const clientAssertionLifetime = time.Minute
+
+// ClientCredentials are the credentials of a client
+type ClientCredentials struct {
+	ClientID string
+	// AuthMethod is the client authentication method
+	//	If empty, it is ClientSecretBasic when a secret is given and ClientAuthNone (public client) otherwise.
+	AuthMethod string
+	// ClientSecret is the secret for ClientSecretBasic and ClientSecretPost authentication
+	ClientSecret string
+	// PrivateKey is the key to sign client assertions for PrivateKeyJWT authentication
+	PrivateKey crypto.Signer
+	// KeyID is the ID of the private key, as registered at the provider (optional)
+	KeyID string
+}
+
+// Validate checks that the credentials are complete for the authentication method
+func (c ClientCredentials) Validate() error {
+	if c.ClientID == "" {
+		return errors.New("client ID is not specified")
+	}
+	switch c.authMethod() {
+	case ClientAuthNone:
+	case ClientSecretBasic, ClientSecretPost:
+		if c.ClientSecret == "" {
+			return errors.New("client secret is not specified")
+		}
+	case PrivateKeyJWT:
+		if c.PrivateKey == nil {
+			return errors.New("private key is not specified")
+		}
+	default:
+		return fmt.Errorf("unsupported client authentication method: %s", c.AuthMethod)
+	}
+	return nil
+}
+
+// authMethod returns the authentication method, resolving the default
+func (c ClientCredentials) authMethod() string {
+	if c.AuthMethod != "" {
+		return c.AuthMethod
+	}
+	if c.ClientSecret != "" {
+		return ClientSecretBasic
+	}
+	return ClientAuthNone
+}
+
+// ClientAssertion returns a signed client assertion for the given audience, i.e. the token endpoint URL
+//	The signing algorithm is selected by the type of the private key: RS256, ES256/ES384/ES512, or EdDSA.
+func (c ClientCredentials) ClientAssertion(audience string) (string, error) {
+	var method jwt.SigningMethod
+	switch key := c.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		method = jwt.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		switch key.Curve.Params().BitSize {
+		case 256:
+			method = jwt.SigningMethodES256
+		case 384:
+			method = jwt.SigningMethodES384
+		case 521:
+			method = jwt.SigningMethodES512
+		default:
+			return "", fmt.Errorf("unsupported curve of private key: %s", key.Curve.Params().Name)
+		}
+	case ed25519.PrivateKey:
+		method = jose.SigningMethodEdDSA
+	default:
+		return "", fmt.Errorf("unsupported private key type: %T", c.PrivateKey)
+	}
+
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", fmt.Errorf("error generating assertion ID: %s", err)
+	}
+	now := time.Now()
+	token := jwt.NewWithClaims(method, jwt.StandardClaims{
+		Issuer:    c.ClientID,
+		Subject:   c.ClientID,
+		Audience:  audience,
+		Id:        hex.EncodeToString(jti),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(clientAssertionLifetime).Unix(),
+	})
+	if c.KeyID != "" {
+		token.Header["kid"] = c.KeyID
+	}
+	return token.SignedString(c.PrivateKey)
+}
+
+// NewTokenRequest returns a POST request of the form to the token endpoint, authenticated with the credentials
+func (c ClientCredentials) NewTokenRequest(tokenEndpoint string, form url.Values) (*http.Request, error) {
+	return c.NewEndpointRequest(tokenEndpoint, tokenEndpoint, form)
+}
+
+// NewEndpointRequest returns a POST request of the form to an endpoint of the provider (e.g. revocation),
+//	authenticated with the credentials. Client assertions are issued for the token endpoint as audience.
+func (c ClientCredentials) NewEndpointRequest(endpoint, tokenEndpoint string, form url.Values) (*http.Request, error) {
+	basic := false
+	switch c.authMethod() {
+	case ClientAuthNone:
+		form.Set("client_id", c.ClientID)
+	case ClientSecretBasic:
+		basic = true
+	case ClientSecretPost:
+		form.Set("client_id", c.ClientID)
+		form.Set("client_secret", c.ClientSecret)
+	case PrivateKeyJWT:
+		assertion, err := c.ClientAssertion(tokenEndpoint)
+		if err != nil {
+			return nil, fmt.Errorf("error creating client assertion: %s", err)
+		}
+		form.Set("client_id", c.ClientID)
+		form.Set("client_assertion_type", ClientAssertionType)
+		form.Set("client_assertion", assertion)
+	default:
+		return nil, fmt.Errorf("unsupported client authentication method: %s", c.AuthMethod)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if basic {
+		// client_secret_basic requires form encoding of the credentials (RFC 6749, Section 2.3.1)
+		req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+	}
+	return req, nil
+}
+
+// LoadPrivateKey loads a PEM encoded private key in PKCS #8, PKCS #1 (RSA), or SEC 1 (EC) form
+func LoadPrivateKey(path string) (crypto.Signer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading private key file: %s", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type: %T", key)
+		}
+		return signer, nil
+	}
+	return nil, fmt.Errorf("unsupported PEM block type: %s", block.Type)
+}
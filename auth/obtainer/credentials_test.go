@@ -0,0 +1,41 @@
+package obtainer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/url"
+	"testing"
+)
+
+func TestClientCredentialsValidate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating EC key: %s", err)
+	}
+	for _, tc := range []struct {
+		credentials ClientCredentials
+		valid       bool
+	}{
+		{ClientCredentials{ClientID: "app"}, true},
+		{ClientCredentials{ClientID: "app", AuthMethod: ClientAuthNone}, true},
+		{ClientCredentials{ClientID: "app", ClientSecret: "secret"}, true},
+		{ClientCredentials{ClientID: "app", AuthMethod: ClientSecretPost, ClientSecret: "secret"}, true},
+		{ClientCredentials{ClientID: "app", AuthMethod: PrivateKeyJWT, PrivateKey: key}, true},
+		{ClientCredentials{}, false},
+		{ClientCredentials{ClientID: "app", AuthMethod: ClientSecretBasic}, false},
+		{ClientCredentials{ClientID: "app", AuthMethod: PrivateKeyJWT}, false},
+		{ClientCredentials{ClientID: "app", AuthMethod: "tls_client_auth"}, false},
+	} {
+		err := tc.credentials.Validate()
+		if (err == nil) != tc.valid {
+			t.Errorf("%+v: expected valid=%t, got error: %v", tc.credentials, tc.valid, err)
+		}
+		// valid credentials can authenticate requests
+		if err == nil {
+			if _, err := tc.credentials.NewTokenRequest("http://localhost/token", url.Values{}); err != nil {
+				t.Errorf("%+v: error creating request: %s", tc.credentials, err)
+			}
+		}
+	}
+}
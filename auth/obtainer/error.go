@@ -0,0 +1,36 @@
+package obtainer
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ProviderError is an error response of the provider, e.g. an OAuth 2.0 error response (RFC 6749, Section 5.2)
+type ProviderError struct {
+	// StatusCode is the HTTP status code of the response
+	StatusCode int
+	// Code is the error code, e.g. invalid_grant, empty if not given
+	Code string
+	// Message describes the error
+	Message string
+}
+
+func (e *ProviderError) Error() string {
+	return e.Message
+}
+
+// Rejected checks whether the provider rejected the grant, e.g. because of invalid user credentials or an expired
+// refresh token, as opposed to failing to process the request or rejecting the client itself
+func (e *ProviderError) Rejected() bool {
+	if e.Code != "" {
+		return e.Code == "invalid_grant"
+	}
+	return e.StatusCode == http.StatusBadRequest || e.StatusCode == http.StatusUnauthorized
+}
+
+// IsRejected checks whether the error is a ProviderError of a rejected grant
+//	Errors of the provider and of the transport, e.g. timeouts, are not rejections.
+func IsRejected(err error) bool {
+	var providerErr *ProviderError
+	return errors.As(err, &providerErr) && providerErr.Rejected()
+}
@@ -4,73 +4,199 @@
 package obtainer
 
 import (
+	"context"
 	"fmt"
 	"sync"
+
+	"github.com/linksmart/go-sec/auth/httpclient"
 )
 
 // Interface methods to login, obtain Service Ticket, and logout
 type Driver interface {
 	// ObtainToken requests a token in exchange for user credentials
-	ObtainToken(serverAddr string, username, password, clientID string) (token interface{}, err error)
-	// TokenString returns the string part of token object (e.g. access_token, id_token strings)
-	TokenString(token interface{}) (tokenString string, err error)
-	// RenewToken renews the token (when applicable) using information inside the token (e.g. refresh_token)
-	RenewToken(serverAddr string, token interface{}, clientID string) (newToken interface{}, err error)
-	// RevokeToken revokes a previously obtained token
-	RevokeToken(serverAddr string, token interface{}) error
+	ObtainToken(serverAddr string, username, password, clientID string) (token *Token, err error)
+	// ObtainClientToken requests a token for the client itself in exchange for client credentials
+	ObtainClientToken(serverAddr string, credentials ClientCredentials) (token *Token, err error)
+	// RenewToken renews the token (when applicable) using information inside the token (e.g. refresh_token),
+	//	authenticating as the given client
+	RenewToken(serverAddr string, token *Token, credentials ClientCredentials) (newToken *Token, err error)
+	// RevokeToken revokes a previously obtained token and ends the session, authenticating as the given client
+	RevokeToken(serverAddr string, token *Token, credentials ClientCredentials) error
+}
+
+// ContextDriver is a Driver whose requests to the provider are bounded by a context
+//	Drivers that do not implement it are wrapped with ContextAdapter.
+type ContextDriver interface {
+	Driver
+	ObtainTokenContext(ctx context.Context, serverAddr string, username, password, clientID string) (token *Token, err error)
+	ObtainClientTokenContext(ctx context.Context, serverAddr string, credentials ClientCredentials) (token *Token, err error)
+	RenewTokenContext(ctx context.Context, serverAddr string, token *Token, credentials ClientCredentials) (newToken *Token, err error)
+	RevokeTokenContext(ctx context.Context, serverAddr string, token *Token, credentials ClientCredentials) error
+}
+
+// ContextAdapter adapts a Driver to ContextDriver
+//	The driver cannot be interrupted, but its result is no longer awaited when the context is done.
+func ContextAdapter(driver Driver) ContextDriver {
+	if d, ok := driver.(ContextDriver); ok {
+		return d
+	}
+	return contextAdapter{driver}
+}
+
+type contextAdapter struct {
+	Driver
+}
+
+func (a contextAdapter) ObtainTokenContext(ctx context.Context, serverAddr string, username, password, clientID string) (*Token, error) {
+	return await(ctx, func() (*Token, error) {
+		return a.ObtainToken(serverAddr, username, password, clientID)
+	})
+}
+
+func (a contextAdapter) ObtainClientTokenContext(ctx context.Context, serverAddr string, credentials ClientCredentials) (*Token, error) {
+	return await(ctx, func() (*Token, error) {
+		return a.ObtainClientToken(serverAddr, credentials)
+	})
+}
+
+func (a contextAdapter) RenewTokenContext(ctx context.Context, serverAddr string, token *Token, credentials ClientCredentials) (*Token, error) {
+	return await(ctx, func() (*Token, error) {
+		return a.RenewToken(serverAddr, token, credentials)
+	})
+}
+
+func (a contextAdapter) RevokeTokenContext(ctx context.Context, serverAddr string, token *Token, credentials ClientCredentials) error {
+	_, err := await(ctx, func() (*Token, error) {
+		return nil, a.RevokeToken(serverAddr, token, credentials)
+	})
+	return err
+}
+
+// await calls fn and waits for its result until the context is done
+func await(ctx context.Context, fn func() (*Token, error)) (*Token, error) {
+	if ctx.Done() == nil {
+		return fn()
+	}
+
+	type result struct {
+		token *Token
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		token, err := fn()
+		done <- result{token, err}
+	}()
+	select {
+	case r := <-done:
+		return r.token, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
 }
 
+// Factory creates the driver of an Obtainer, given its configuration
+//	It is called on Setup. The HTTPClient of the configuration is always set and should be used for all requests to
+//	the provider. The returned driver must be safe for concurrent use.
+type Factory func(conf Conf) (Driver, error)
+
 var (
 	driversMu sync.Mutex
-	drivers   = make(map[string]Driver)
+	drivers   = make(map[string]Factory)
 )
 
 // Register registers a driver (called by a the driver package)
+//	The driver instance is shared by all Obtainers. Drivers with per-configuration state, e.g. the HTTP client,
+//	should use RegisterFactory instead.
 func Register(name string, driver Driver) {
-	driversMu.Lock()
-	defer driversMu.Unlock()
 	if driver == nil {
 		panic("auth obtainer driver is nil")
 	}
-	drivers[name] = driver
+	RegisterFactory(name, func(Conf) (Driver, error) {
+		return driver, nil
+	})
+}
+
+// RegisterFactory registers a driver factory (called by a the driver package)
+func RegisterFactory(name string, factory Factory) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if factory == nil {
+		panic("auth obtainer driver factory is nil")
+	}
+	drivers[name] = factory
 }
 
 // Setup configures and returns the Obtainer
 func Setup(name, serverAddr string) (*Obtainer, error) {
+	return SetupFromConf(Conf{
+		Provider:    name,
+		ProviderURL: serverAddr,
+	})
+}
+
+// SetupFromConf configures and returns the Obtainer given the configuration
+//	Only the provider and the HTTP client settings are used; the credentials are used by Client.
+func SetupFromConf(conf Conf) (*Obtainer, error) {
 	driversMu.Lock()
-	driveri, ok := drivers[name]
+	factory, ok := drivers[conf.Provider]
 	driversMu.Unlock()
 	if !ok {
-		return nil, fmt.Errorf("unknown obtainer: '%s' (forgot to import driver?)", name)
+		return nil, fmt.Errorf("unknown obtainer: '%s' (forgot to import driver?)", conf.Provider)
+	}
+	httpClient, err := httpclient.Resolve(conf.HTTPClient, conf.Transport)
+	if err != nil {
+		return nil, fmt.Errorf("error creating HTTP client: %s", err)
+	}
+	conf.HTTPClient = httpClient
+	driveri, err := factory(conf)
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s obtainer: %s", conf.Provider, err)
 	}
 
 	return &Obtainer{
-		driver:     driveri,
-		serverAddr: serverAddr,
+		driver:     ContextAdapter(driveri),
+		serverAddr: conf.ProviderURL,
 	}, nil
 }
 
 // Obtainer struct
 type Obtainer struct {
-	driver     Driver
+	driver     ContextDriver
 	serverAddr string
 }
 
 // Wrapper functions
 // These functions are public
 
-func (o *Obtainer) ObtainToken(username, password, clientID string) (token interface{}, err error) {
-	return o.driver.ObtainToken(o.serverAddr, username, password, clientID)
+func (o *Obtainer) ObtainToken(username, password, clientID string) (token *Token, err error) {
+	return o.ObtainTokenContext(context.Background(), username, password, clientID)
+}
+
+func (o *Obtainer) ObtainTokenContext(ctx context.Context, username, password, clientID string) (token *Token, err error) {
+	return o.driver.ObtainTokenContext(ctx, o.serverAddr, username, password, clientID)
+}
+
+func (o *Obtainer) ObtainClientToken(credentials ClientCredentials) (token *Token, err error) {
+	return o.ObtainClientTokenContext(context.Background(), credentials)
+}
+
+func (o *Obtainer) ObtainClientTokenContext(ctx context.Context, credentials ClientCredentials) (token *Token, err error) {
+	return o.driver.ObtainClientTokenContext(ctx, o.serverAddr, credentials)
+}
+
+func (o *Obtainer) RenewToken(token *Token, credentials ClientCredentials) (newToken *Token, err error) {
+	return o.RenewTokenContext(context.Background(), token, credentials)
 }
 
-func (o *Obtainer) TokenString(token interface{}) (tokenString string, err error) {
-	return o.driver.TokenString(token)
+func (o *Obtainer) RenewTokenContext(ctx context.Context, token *Token, credentials ClientCredentials) (newToken *Token, err error) {
+	return o.driver.RenewTokenContext(ctx, o.serverAddr, token, credentials)
 }
 
-func (o *Obtainer) RenewToken(token interface{}, clientID string) (newToken interface{}, err error) {
-	return o.driver.RenewToken(o.serverAddr, token, clientID)
+func (o *Obtainer) RevokeToken(token *Token, credentials ClientCredentials) error {
+	return o.RevokeTokenContext(context.Background(), token, credentials)
 }
 
-func (o *Obtainer) RevokeToken(token interface{}) error {
-	return o.driver.RevokeToken(o.serverAddr, token)
+func (o *Obtainer) RevokeTokenContext(ctx context.Context, token *Token, credentials ClientCredentials) error {
+	return o.driver.RevokeTokenContext(ctx, o.serverAddr, token, credentials)
 }
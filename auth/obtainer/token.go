@@ -0,0 +1,166 @@
+package obtainer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// Token uses, i.e. the token string to send to services
+const (
+	UseIDToken     = "id_token"
+	UseAccessToken = "access_token"
+)
+
+// Token is a token obtained from a provider
+type Token struct {
+	AccessToken  string
+	IDToken      string
+	RefreshToken string
+	// TokenType is the type of the access token, e.g. Bearer
+	TokenType string
+	// Expiry is the expiry of the access token, zero if unknown
+	Expiry time.Time
+	// RefreshExpiry is the expiry of the refresh token, zero if unknown
+	RefreshExpiry time.Time
+	// Scopes are the scopes granted to the access token
+	Scopes []string
+	// Extra are other fields of the token response
+	Extra map[string]interface{}
+}
+
+// TokenString returns the token string for the given use
+//	If use is empty, it returns the ID token if available and the access token otherwise.
+func (t *Token) TokenString(use string) (string, error) {
+	switch use {
+	case "":
+		if t.IDToken != "" {
+			return t.IDToken, nil
+		}
+		if t.AccessToken != "" {
+			return t.AccessToken, nil
+		}
+		return "", fmt.Errorf("token has no ID token or access token")
+	case UseIDToken:
+		if t.IDToken != "" {
+			return t.IDToken, nil
+		}
+		return "", fmt.Errorf("token has no ID token")
+	case UseAccessToken:
+		if t.AccessToken != "" {
+			return t.AccessToken, nil
+		}
+		return "", fmt.Errorf("token has no access token")
+	}
+	return "", fmt.Errorf("unknown token use: %s", use)
+}
+
+// copy returns a deep copy of the token, so that the copy can be modified without affecting the original
+func (t *Token) copy() *Token {
+	if t == nil {
+		return nil
+	}
+	copied := *t
+	if t.Scopes != nil {
+		copied.Scopes = append([]string(nil), t.Scopes...)
+	}
+	if t.Extra != nil {
+		copied.Extra = copyValue(t.Extra).(map[string]interface{})
+	}
+	return &copied
+}
+
+// copyValue returns a deep copy of a value decoded from JSON
+func copyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for name, value := range v {
+			copied[name] = copyValue(value)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, value := range v {
+			copied[i] = copyValue(value)
+		}
+		return copied
+	}
+	return v
+}
+
+// NewTokenFromResponse returns the token given in a token response (RFC 6749, Section 5.1)
+//	The expiry is calculated from expires_in and refresh_expires_in. If no expires_in is given, the expiry is taken
+//	from the exp claim of the access token, if it is a JWT.
+func NewTokenFromResponse(body []byte) (*Token, error) {
+	var fields map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(string(body)))
+	decoder.UseNumber()
+	err := decoder.Decode(&fields)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	token := &Token{Extra: make(map[string]interface{})}
+	for name, value := range fields {
+		s, _ := value.(string)
+		switch name {
+		case "access_token":
+			token.AccessToken = s
+		case "id_token":
+			token.IDToken = s
+		case "refresh_token":
+			token.RefreshToken = s
+		case "token_type":
+			token.TokenType = s
+		case "scope":
+			token.Scopes = strings.Fields(s)
+		case "expires_in":
+			token.Expiry = expiresIn(now, value)
+		case "refresh_expires_in":
+			token.RefreshExpiry = expiresIn(now, value)
+		default:
+			token.Extra[name] = value
+		}
+	}
+	if token.Expiry.IsZero() {
+		token.Expiry = jwtExpiry(token.AccessToken)
+	}
+	return token, nil
+}
+
+// expiresIn returns the expiry given a lifetime in seconds, zero if not positive
+func expiresIn(now time.Time, value interface{}) time.Time {
+	n, ok := value.(json.Number)
+	if !ok {
+		return time.Time{}
+	}
+	seconds, err := n.Int64()
+	if err != nil || seconds <= 0 {
+		return time.Time{}
+	}
+	return now.Add(time.Duration(seconds) * time.Second)
+}
+
+// jwtExpiry returns the time in the exp claim of a JWT, without verifying it
+func jwtExpiry(tokenString string) time.Time {
+	parts := strings.Split(tokenString, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	b, err := jwt.DecodeSegment(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if json.Unmarshal(b, &claims) != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Exp, 0)
+}
@@ -0,0 +1,78 @@
+package obtainer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewTokenFromResponse(t *testing.T) {
+	token, err := NewTokenFromResponse([]byte(`{
+		"access_token": "access",
+		"id_token": "id",
+		"refresh_token": "refresh",
+		"token_type": "Bearer",
+		"expires_in": 300,
+		"refresh_expires_in": 1800,
+		"scope": "openid profile",
+		"session_state": "abc"
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if token.AccessToken != "access" || token.IDToken != "id" || token.RefreshToken != "refresh" || token.TokenType != "Bearer" {
+		t.Fatalf("Unexpected token: %+v", token)
+	}
+	if len(token.Scopes) != 2 || token.Scopes[1] != "profile" || token.Extra["session_state"] != "abc" {
+		t.Fatalf("Unexpected scopes or extras: %+v", token)
+	}
+	if d := time.Until(token.Expiry); d < 299*time.Second || d > 300*time.Second {
+		t.Fatalf("Unexpected expiry in %s", d)
+	}
+	if d := time.Until(token.RefreshExpiry); d < 1799*time.Second || d > 1800*time.Second {
+		t.Fatalf("Unexpected refresh expiry in %s", d)
+	}
+
+	for use, expected := range map[string]string{"": "id", UseIDToken: "id", UseAccessToken: "access"} {
+		if s, err := token.TokenString(use); err != nil || s != expected {
+			t.Fatalf("Use %q: expected %s, got %s (%v)", use, expected, s, err)
+		}
+	}
+	token.IDToken = ""
+	if s, _ := token.TokenString(""); s != "access" {
+		t.Fatalf("Expected access token without ID token, got %s", s)
+	}
+	if _, err := token.TokenString(UseIDToken); err == nil || err.Error() != "token has no ID token" {
+		t.Fatalf("Expected error without ID token, got %v", err)
+	}
+	token.AccessToken = ""
+	if _, err := token.TokenString(""); err == nil || err.Error() != "token has no ID token or access token" {
+		t.Fatalf("Expected error without tokens, got %v", err)
+	}
+}
+
+func TestTokenCopy(t *testing.T) {
+	token, err := NewTokenFromResponse([]byte(`{
+		"access_token": "access",
+		"scope": "openid profile",
+		"authorization_details": [{"type": "account", "actions": ["read"]}]
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	client := &Client{token: token}
+
+	// modifying the copy does not affect the token of the client
+	copied := client.Token()
+	copied.Scopes[0] = "modified"
+	copied.Extra["authorization_details"].([]interface{})[0].(map[string]interface{})["type"] = "modified"
+	copied.Extra["session_state"] = "modified"
+
+	copied = client.Token()
+	details := copied.Extra["authorization_details"].([]interface{})[0].(map[string]interface{})
+	if copied.Scopes[0] != "openid" || details["type"] != "account" || copied.Extra["session_state"] != nil {
+		t.Fatalf("Token of the client was modified: %+v", copied)
+	}
+	if (&Client{}).Token() != nil {
+		t.Fatalf("Expected no token")
+	}
+}
@@ -0,0 +1,85 @@
+package obtainer
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// Transport is an http.RoundTripper that authenticates requests with the tokens of a Client
+//	When a request is rejected with 401 Unauthorized, the token is renewed and the request is retried once,
+//	provided that its body can be replayed (i.e. it has no body or GetBody is set).
+type Transport struct {
+	// Client obtains the tokens
+	Client *Client
+	// Base is the transport to send the requests, http.DefaultTransport if nil
+	Base http.RoundTripper
+}
+
+// NewTransport returns a transport that authenticates requests with the tokens of the client
+func NewTransport(client *Client, base http.RoundTripper) *Transport {
+	return &Transport{
+		Client: client,
+		Base:   base,
+	}
+}
+
+// RoundTrip sends the request with a bearer token
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tokenString, err := t.Client.ObtainContext(req.Context())
+	if err != nil {
+		closeBody(req)
+		return nil, fmt.Errorf("error obtaining token: %s", err)
+	}
+
+	res, err := t.base().RoundTrip(authorize(req, tokenString))
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		return res, err
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		// the body was consumed and cannot be sent again
+		return res, nil
+	}
+
+	tokenString, err = t.Client.renewRejected(req.Context(), tokenString)
+	if err != nil {
+		// keep the original response, which tells why the request was rejected
+		return res, nil
+	}
+	retry := authorize(req, tokenString)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return res, nil
+		}
+		retry.Body = body
+	}
+	// discard the rejected response to reuse the connection
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+
+	return t.base().RoundTrip(retry)
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+// authorize returns a copy of the request with the token in the Authorization header
+//	A RoundTripper must not modify the original request.
+func authorize(req *http.Request, tokenString string) *http.Request {
+	authorized := req.Clone(req.Context())
+	authorized.Header.Set("Authorization", "Bearer "+tokenString)
+	return authorized
+}
+
+// closeBody closes the request body, as a RoundTripper must do even on errors
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
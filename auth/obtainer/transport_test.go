@@ -0,0 +1,75 @@
+package obtainer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTransport(t *testing.T) {
+	// the server accepts only renewed tokens and echoes the body
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer renewed-") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	driver := &fakeDriver{lifetime: time.Hour, refreshLifetime: time.Hour}
+	client := &http.Client{Transport: NewTransport(newFakeClient(t, "fake-transport", driver), nil)}
+
+	// concurrent requests rejected with the same token renew it once
+	var wg sync.WaitGroup
+	codes := make(chan int, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := client.Post(server.URL, "text/plain", bytes.NewReader([]byte("hello")))
+			if err != nil {
+				t.Errorf("Unexpected error: %s", err)
+				return
+			}
+			body, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if string(body) != "hello" {
+				t.Errorf("Body not replayed: %q", body)
+			}
+			codes <- res.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", code)
+		}
+	}
+	if _, renewed := driver.counts(); renewed != 1 {
+		t.Fatalf("Expected a single renewal, got %d", renewed)
+	}
+
+	// requests with bodies that cannot be replayed are not retried
+	driver2 := &fakeDriver{lifetime: time.Hour, refreshLifetime: time.Hour}
+	client = &http.Client{Transport: NewTransport(newFakeClient(t, "fake-transport-2", driver2), nil)}
+	req, _ := http.NewRequest(http.MethodPost, server.URL, ioutil.NopCloser(strings.NewReader("hello")))
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected 401, got %d", res.StatusCode)
+	}
+	if _, renewed := driver2.counts(); renewed != 0 {
+		t.Fatalf("Expected no renewal, got %d", renewed)
+	}
+}
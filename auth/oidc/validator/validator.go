@@ -0,0 +1,190 @@
+// Copyright 2014-2016 Fraunhofer Institute for Applied Information Technology FIT
+
+// Package validator implements OpenID Connect token validation for any OpenID Provider
+//	The provider's metadata is obtained using OpenID Connect Discovery and the signing keys from its JWK Set.
+package validator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/linksmart/go-sec/auth/jose"
+	"github.com/linksmart/go-sec/auth/validator"
+	"github.com/linksmart/go-sec/authz"
+)
+
+const (
+	DriverName        = "oidc"
+	DiscoveryEndpoint = "/.well-known/openid-configuration"
+)
+
+// OIDCValidator validates the tokens of an OpenID Provider
+//	It keeps the discovered metadata and keys of the provider and is safe for concurrent use.
+//	Every Validator gets its own instance on Setup.
+type OIDCValidator struct {
+	// Algorithms are the accepted signing algorithms, all supported by jose if empty
+	Algorithms []string
+	// Client is the HTTP client for requests to the provider, http.DefaultClient if nil
+	Client *http.Client
+
+	mu       sync.Mutex
+	issuer   string
+	provider *provider
+	// attempted and lastErr rate-limit discovery while the provider is unreachable
+	attempted time.Time
+	lastErr   error
+	// discovering serializes discoveries
+	discovering chan struct{}
+}
+
+// provider is the metadata of an OpenID Provider
+type provider struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+	keys    *jose.KeyCache
+}
+
+func init() {
+	// Register the driver as a auth/validator
+	validator.RegisterFactory(DriverName, func(conf validator.Conf) (validator.Driver, error) {
+		return &OIDCValidator{Algorithms: conf.Algorithms, Client: conf.HTTPClient}, nil
+	})
+}
+
+// Validate validates the token
+//	The serverAddr is the issuer URL of the OpenID Provider, e.g. https://accounts.example.com
+func (v *OIDCValidator) Validate(serverAddr, clientID, tokenString string) (bool, *authz.Claims, error) {
+	return v.ValidateContext(context.Background(), serverAddr, clientID, tokenString)
+}
+
+// ValidateContext validates the token, with the context bounding the discovery and the requests for the keys
+func (v *OIDCValidator) ValidateContext(ctx context.Context, serverAddr, clientID, tokenString string) (bool, *authz.Claims, error) {
+
+	p, err := v.discovered(ctx, serverAddr)
+	if err != nil {
+		return false, nil, err
+	}
+
+	claims, status := jose.ParseToken(tokenString, jose.KeyFuncContext(ctx, p.keys, v.Algorithms))
+	if status != "" {
+		return false, &authz.Claims{Status: status}, nil
+	}
+
+	// Validate the other claims
+	if status := jose.VerifyClaims(claims, p.Issuer, clientID); status != "" {
+		return false, &authz.Claims{Status: status}, nil
+	}
+
+	// return user profile from claims
+	profile := &authz.Claims{Extra: claims}
+	validator.ClaimsMappingPresets["go-sec"].Apply(profile, clientID)
+	return true, profile, nil
+}
+
+// discovered returns the metadata and keys of the provider, discovering it on first use
+//	The keys are fetched on demand and refreshed to follow key rotations. Failed discoveries are not retried
+//	more often than jose.DefaultMinRefreshInterval, unless they were cut short by the context. Only one discovery
+//	runs at a time and waiting for it is bounded by the context.
+func (v *OIDCValidator) discovered(ctx context.Context, issuer string) (*provider, error) {
+	p, found, err := v.lookup(issuer)
+	if found {
+		return p, err
+	}
+
+	v.mu.Lock()
+	if v.discovering == nil {
+		v.discovering = make(chan struct{}, 1)
+	}
+	discovering := v.discovering
+	v.mu.Unlock()
+
+	select {
+	case discovering <- struct{}{}:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("error discovering the provider: %s", ctx.Err())
+	}
+	defer func() { <-discovering }()
+
+	// discovered while waiting
+	p, found, err = v.lookup(issuer)
+	if found {
+		return p, err
+	}
+
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	p, err = discover(ctx, client, issuer)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.issuer, v.provider = issuer, nil
+	if err != nil {
+		if ctx.Err() != nil {
+			// not the provider's fault, retry with the next request
+			v.attempted = time.Time{}
+			return nil, fmt.Errorf("error discovering the provider: %s", err)
+		}
+		v.attempted = time.Now()
+		v.lastErr = fmt.Errorf("error discovering the provider: %s", err)
+		return nil, v.lastErr
+	}
+	p.keys = jose.NewKeyCache(func(ctx context.Context) (*jose.KeySet, error) {
+		return jose.FetchKeySetWithClient(ctx, client, p.JWKSURI)
+	})
+	v.provider = p
+	return p, nil
+}
+
+// lookup returns the provider if it was discovered, or the error of a recent failed discovery
+func (v *OIDCValidator) lookup(issuer string) (p *provider, found bool, err error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.issuer != issuer {
+		return nil, false, nil
+	}
+	if v.provider != nil {
+		return v.provider, true, nil
+	}
+	if !v.attempted.IsZero() && time.Since(v.attempted) < jose.DefaultMinRefreshInterval {
+		return nil, true, v.lastErr
+	}
+	return nil, false, nil
+}
+
+// discover retrieves the provider metadata (OpenID Connect Discovery 1.0, Section 4)
+func discover(ctx context.Context, client *http.Client, issuer string) (*provider, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(issuer, "/")+DiscoveryEndpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%d %s", res.StatusCode, http.StatusText(res.StatusCode))
+	}
+
+	var p provider
+	err = json.NewDecoder(res.Body).Decode(&p)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding the provider metadata: %s", err)
+	}
+	if p.Issuer != issuer {
+		return nil, fmt.Errorf("issuer in the provider metadata does not match: %s", p.Issuer)
+	}
+	if p.JWKSURI == "" {
+		return nil, fmt.Errorf("no jwks_uri in the provider metadata")
+	}
+	return &p, nil
+}
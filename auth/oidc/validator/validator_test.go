@@ -0,0 +1,178 @@
+package validator
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+const testClientID = "test-client"
+
+// testIssuer is a local OpenID Provider serving discovery metadata and keys
+type testIssuer struct {
+	*httptest.Server
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating RSA key: %s", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating EC key: %s", err)
+	}
+	issuer := &testIssuer{rsaKey: rsaKey, ecKey: ecKey}
+
+	encode := func(i *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(i.Bytes())
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(DiscoveryEndpoint, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   issuer.URL,
+			"jwks_uri": issuer.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{
+				{"kty": "RSA", "kid": "rsa1", "use": "sig", "n": encode(rsaKey.N), "e": encode(big.NewInt(int64(rsaKey.E)))},
+				{"kty": "EC", "kid": "ec1", "crv": "P-256", "x": encode(ecKey.X), "y": encode(ecKey.Y)},
+				{"kty": "oct", "kid": "hmac1", "k": "c2VjcmV0"},
+			},
+		})
+	})
+	issuer.Server = httptest.NewServer(mux)
+	return issuer
+}
+
+func (issuer *testIssuer) sign(t *testing.T, method jwt.SigningMethod, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	var key interface{} = issuer.rsaKey
+	if _, ok := method.(*jwt.SigningMethodECDSA); ok {
+		key = issuer.ecKey
+	}
+	tokenString, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Error signing token: %s", err)
+	}
+	return tokenString
+}
+
+func (issuer *testIssuer) claims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":                issuer.URL,
+		"aud":                testClientID,
+		"exp":                time.Now().Add(time.Minute).Unix(),
+		"preferred_username": "john",
+		"groups":             []string{"admin"},
+		"tenant":             "acme",
+	}
+}
+
+func TestValidate(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.Close()
+	v := &OIDCValidator{}
+
+	t.Run("valid", func(t *testing.T) {
+		for _, method := range []jwt.SigningMethod{jwt.SigningMethodRS256, jwt.SigningMethodES256} {
+			kid := "rsa1"
+			if method == jwt.SigningMethodES256 {
+				kid = "ec1"
+			}
+			valid, claims, err := v.Validate(issuer.URL, testClientID, issuer.sign(t, method, kid, issuer.claims()))
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if !valid {
+				t.Fatalf("Token signed with %s not valid: %s", method.Alg(), claims.Status)
+			}
+			if claims.Username != "john" || len(claims.Groups) != 1 || claims.Groups[0] != "admin" || claims.Extra["tenant"] != "acme" {
+				t.Fatalf("Unexpected claims: %+v", claims)
+			}
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		modify := map[string]func(jwt.MapClaims){
+			"wrong issuer":   func(c jwt.MapClaims) { c["iss"] = "https://other.example.com" },
+			"wrong audience": func(c jwt.MapClaims) { c["aud"] = "other-client" },
+			"no azp":         func(c jwt.MapClaims) { c["aud"] = []string{testClientID, "other-client"} },
+			"wrong azp":      func(c jwt.MapClaims) { c["azp"] = "other-client" },
+			"expired":        func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() },
+			"no expiry":      func(c jwt.MapClaims) { delete(c, "exp") },
+		}
+		for name, fn := range modify {
+			claims := issuer.claims()
+			fn(claims)
+			valid, profile, err := v.Validate(issuer.URL, testClientID, issuer.sign(t, jwt.SigningMethodRS256, "rsa1", claims))
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %s", name, err)
+			}
+			if valid || profile.Status == "" {
+				t.Fatalf("%s: token was accepted", name)
+			}
+		}
+
+		// key mismatches
+		for kid, method := range map[string]jwt.SigningMethod{
+			"unknown": jwt.SigningMethodRS256,
+			"ec1":     jwt.SigningMethodRS256,
+		} {
+			valid, _, err := v.Validate(issuer.URL, testClientID, issuer.sign(t, method, kid, issuer.claims()))
+			if err != nil || valid {
+				t.Fatalf("Token with key %s was accepted: %v", kid, err)
+			}
+		}
+		hmacToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, issuer.claims()).SignedString([]byte("secret"))
+		if valid, _, _ := v.Validate(issuer.URL, testClientID, hmacToken); valid {
+			t.Fatalf("HMAC token was accepted")
+		}
+	})
+}
+
+func TestDiscoveryContext(t *testing.T) {
+	hang := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a provider that does not respond
+		<-hang
+	}))
+	defer server.Close()
+	defer close(hang)
+	v := &OIDCValidator{}
+
+	// a discovery that hangs
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		v.ValidateContext(ctx, server.URL, testClientID, "token")
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// waiting for it is bounded by the request context
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, _, err := v.ValidateContext(ctx, server.URL, testClientID, "token"); err == nil {
+		t.Fatalf("Expected error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Waited %s for the hanging discovery", elapsed)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2014-2016 Fraunhofer Institute for Applied Information Technology FIT
+
+// Package validator implements offline token validation with trusted keys given in the configuration
+//	The keys are loaded on Setup from PEM files, an inline JWK Set, or a JWK Set file. No network access is required.
+package validator
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/linksmart/go-sec/auth/jose"
+	"github.com/linksmart/go-sec/auth/validator"
+	"github.com/linksmart/go-sec/authz"
+)
+
+const DriverName = "static"
+
+// StaticValidator validates tokens signed with a fixed set of keys
+//	It is safe for concurrent use.
+type StaticValidator struct {
+	// Algorithms are the accepted signing algorithms, all supported by jose if empty
+	Algorithms []string
+
+	keys *jose.KeySet
+}
+
+// NewStaticValidator returns a validator for tokens signed with the given keys
+func NewStaticValidator(keys *jose.KeySet, algorithms []string) *StaticValidator {
+	return &StaticValidator{
+		Algorithms: algorithms,
+		keys:       keys,
+	}
+}
+
+func init() {
+	// Register the driver as a auth/validator
+	validator.RegisterFactory(DriverName, func(conf validator.Conf) (validator.Driver, error) {
+		if conf.StaticKeys == nil {
+			return nil, fmt.Errorf("static keys are not specified")
+		}
+		keys, err := LoadKeys(*conf.StaticKeys)
+		if err != nil {
+			return nil, err
+		}
+		return NewStaticValidator(keys, conf.Algorithms), nil
+	})
+}
+
+// LoadKeys loads the keys given in the configuration
+//	Keys from PEM files get the file name without extension as key ID, e.g. key1 for /etc/keys/key1.pem.
+func LoadKeys(conf validator.StaticKeysConf) (*jose.KeySet, error) {
+	keys := &jose.KeySet{}
+	for _, path := range conf.PEMFiles {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading PEM file: %s", err)
+		}
+		publicKeys, err := jose.ParsePEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing PEM file %s: %s", path, err)
+		}
+		kid := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		for _, publicKey := range publicKeys {
+			keys.Keys = append(keys.Keys, jose.Key{ID: kid, Use: "sig", Key: publicKey})
+		}
+	}
+	if len(conf.JWKS) != 0 {
+		set, err := jose.ParseKeySet(conf.JWKS)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing inline JWKS: %s", err)
+		}
+		keys.Keys = append(keys.Keys, set.Keys...)
+	}
+	if conf.JWKSFile != "" {
+		data, err := ioutil.ReadFile(conf.JWKSFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading JWKS file: %s", err)
+		}
+		set, err := jose.ParseKeySet(data)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing JWKS file %s: %s", conf.JWKSFile, err)
+		}
+		keys.Keys = append(keys.Keys, set.Keys...)
+	}
+	if len(keys.Keys) == 0 {
+		return nil, fmt.Errorf("no signing keys found")
+	}
+	return keys, nil
+}
+
+// ValidateContext validates the token
+//	The keys are local, so there are no requests to bound by the context.
+func (v *StaticValidator) ValidateContext(ctx context.Context, serverAddr, clientID, tokenString string) (bool, *authz.Claims, error) {
+	return v.Validate(serverAddr, clientID, tokenString)
+}
+
+// Validate validates the token
+//	The serverAddr is the expected issuer of the tokens.
+func (v *StaticValidator) Validate(serverAddr, clientID, tokenString string) (bool, *authz.Claims, error) {
+
+	claims, status := jose.ParseToken(tokenString, jose.KeyFunc(v.keys, v.Algorithms))
+	if status != "" {
+		return false, &authz.Claims{Status: status}, nil
+	}
+
+	// Validate the other claims
+	if status := jose.VerifyClaims(claims, serverAddr, clientID); status != "" {
+		return false, &authz.Claims{Status: status}, nil
+	}
+
+	// return user profile from claims
+	profile := &authz.Claims{Extra: claims}
+	validator.ClaimsMappingPresets["go-sec"].Apply(profile, clientID)
+	return true, profile, nil
+}
@@ -0,0 +1,159 @@
+package validator
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/linksmart/go-sec/auth/validator"
+	"github.com/linksmart/go-sec/authz"
+)
+
+const (
+	testIssuer   = "https://auth.example.com"
+	testClientID = "test-client"
+)
+
+func TestHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-sec")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// RSA key in a PEM file
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating RSA key: %s", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Error encoding RSA key: %s", err)
+	}
+	pemFile := filepath.Join(dir, "key1.pem")
+	err = ioutil.WriteFile(pemFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatalf("Error writing PEM file: %s", err)
+	}
+
+	// EC key in an inline JWKS
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating EC key: %s", err)
+	}
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "EC", "kid": "key2", "crv": "P-256",
+			"x": base64.RawURLEncoding.EncodeToString(ecKey.X.Bytes()),
+			"y": base64.RawURLEncoding.EncodeToString(ecKey.Y.Bytes()),
+		}},
+	})
+
+	v, err := validator.SetupFromConf(validator.Conf{
+		Enabled:     true,
+		Provider:    DriverName,
+		ProviderURL: testIssuer,
+		ClientID:    testClientID,
+		StaticKeys: &validator.StaticKeysConf{
+			PEMFiles: []string{pemFile},
+			JWKS:     jwks,
+		},
+		Authz: authz.Conf{
+			Enabled: true,
+			Rules: []authz.Rule{
+				{Paths: []string{"/data"}, Methods: []string{"GET"}, Users: []string{"john", "jane"}},
+				{Paths: []string{"/admin"}, Methods: []string{"GET"}, Groups: []string{"admin"}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error setting up validator: %s", err)
+	}
+	handler := v.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := validator.ClaimsFromContext(r.Context())
+		fmt.Fprint(w, claims.Username)
+	}))
+
+	sign := func(method jwt.SigningMethod, kid string, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = kid
+		var key interface{} = rsaKey
+		if method == jwt.SigningMethodES256 {
+			key = ecKey
+		}
+		tokenString, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("Error signing token: %s", err)
+		}
+		return tokenString
+	}
+	claims := func(username string, groups ...string) jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":                testIssuer,
+			"aud":                testClientID,
+			"exp":                time.Now().Add(time.Minute).Unix(),
+			"preferred_username": username,
+			"groups":             groups,
+		}
+	}
+	otherIssuer := claims("john")
+	otherIssuer["iss"] = "https://other.example.com"
+
+	for _, tc := range []struct {
+		name  string
+		path  string
+		token string
+		code  int
+	}{
+		{"PEM key", "/data", sign(jwt.SigningMethodRS256, "key1", claims("john")), http.StatusOK},
+		{"JWKS key", "/data", sign(jwt.SigningMethodES256, "key2", claims("jane")), http.StatusOK},
+		{"group", "/admin", sign(jwt.SigningMethodRS256, "key1", claims("john", "admin")), http.StatusOK},
+		{"forbidden", "/admin", sign(jwt.SigningMethodRS256, "key1", claims("john")), http.StatusForbidden},
+		{"unknown key", "/data", sign(jwt.SigningMethodRS256, "key2", claims("john")), http.StatusUnauthorized},
+		{"other issuer", "/data", sign(jwt.SigningMethodRS256, "key1", otherIssuer), http.StatusUnauthorized},
+		{"anonymous", "/data", "", http.StatusUnauthorized},
+	} {
+		r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tc.code {
+			t.Fatalf("%s: expected %d, got %d: %s", tc.name, tc.code, w.Code, w.Body)
+		}
+	}
+}
+
+func TestSetupErrors(t *testing.T) {
+	for name, keys := range map[string]*validator.StaticKeysConf{
+		"no keys":       nil,
+		"missing file":  {PEMFiles: []string{"/nonexistent/key.pem"}},
+		"invalid JWKS":  {JWKS: json.RawMessage(`{"keys": 1}`)},
+		"no usable key": {JWKS: json.RawMessage(`{"keys": [{"kty": "oct", "k": "c2VjcmV0"}]}`)},
+	} {
+		_, err := validator.SetupFromConf(validator.Conf{
+			Provider:    DriverName,
+			ProviderURL: testIssuer,
+			ClientID:    testClientID,
+			StaticKeys:  keys,
+		})
+		if err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
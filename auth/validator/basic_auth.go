@@ -1,7 +1,13 @@
 package validator
 
 import (
+	"container/list"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -9,73 +15,370 @@ import (
 	"time"
 
 	"github.com/linksmart/go-sec/auth/obtainer"
+	"github.com/linksmart/go-sec/authz"
 )
 
-// Cached clients for Basic auth
-var (
-	clientsMu sync.Mutex
-	clients   = make(map[string]*obtainer.Client)
-)
-
-const clientExpiration = 10 * time.Minute
+// basicAuth authenticates the given credentials
+//	It returns a token obtained for the credentials, or the claims of the user when the driver verifies the credentials
+//	itself (see BasicDriver). Successful logins are cached and tokens are only renewed if no longer valid.
+func (v *Validator) basicAuth(ctx context.Context, credentials string) (string, *authz.Claims, int, error) {
 
-// basicAuth obtains a token for the given credentials
-//	Tokens are cached by obtainer client and are only renewed if no longer valid
-func (v *Validator) basicAuth(credentials string) (string, int, error) {
+	b, err := base64.StdEncoding.DecodeString(credentials)
+	if err != nil {
+		return "", nil, http.StatusBadRequest, fmt.Errorf("basic auth: invalid encoding: %s", err)
+	}
 
-	clientsMu.Lock()
-	client, found := clients[credentials]
-	if !found {
-		defer clientsMu.Unlock()
+	pair := strings.SplitN(string(b), ":", 2)
+	if len(pair) != 2 {
+		return "", nil, http.StatusBadRequest, fmt.Errorf("basic auth: invalid format for credentials")
+	}
+	username, password := pair[0], pair[1]
+	key := v.basicCache.key(b)
 
-		b, err := base64.StdEncoding.DecodeString(credentials)
+	if entry, found := v.basicCache.get(key); found {
+		if entry.claims != nil {
+			return "", copyClaims(entry.claims), http.StatusOK, nil
+		}
+		tokenString, errCode, err := v.obtainValidToken(ctx, entry.client)
 		if err != nil {
-			return "", http.StatusBadRequest, fmt.Errorf("basic auth: invalid encoding: %s", err)
+			if errCode == http.StatusUnauthorized && ctx.Err() == nil {
+				// e.g. the password was changed, log in again with the next request
+				v.basicCache.remove(key)
+			}
+			return "", nil, errCode, fmt.Errorf("basic auth: %s", err)
 		}
+		return tokenString, nil, http.StatusOK, nil
+	}
 
-		pair := strings.SplitN(string(b), ":", 2)
-		if len(pair) != 2 {
-			return "", http.StatusBadRequest, fmt.Errorf("basic auth: invalid format for credentials")
+	login, first, throttled := v.basicCache.begin(key, username)
+	if throttled {
+		return "", nil, http.StatusTooManyRequests, fmt.Errorf("basic auth: too many failed logins, try again later")
+	}
+	if !first {
+		// the same credentials are being logged in by another request
+		select {
+		case <-login.done:
+		case <-ctx.Done():
+			return "", nil, http.StatusServiceUnavailable, fmt.Errorf("basic auth: %s", ctx.Err())
 		}
+		return login.result()
+	}
 
-		client, err = obtainer.NewClient(v.driverName, v.serverAddr, pair[0], pair[1], v.clientID)
-		if err != nil {
-			return "", http.StatusInternalServerError, fmt.Errorf("basic auth: unable to create a client to obtain tokens: %s", err)
-		}
+	var client *obtainer.Client
+	if v.basicDriver != nil {
+		v.validateBasic(ctx, login, username, password)
+	} else {
+		client = v.obtainBasic(ctx, login, username, password)
+	}
+	// only rejected credentials count as failures, not errors of the provider or cancelled requests
+	failed := login.errCode == http.StatusUnauthorized && ctx.Err() == nil
+	v.basicCache.end(key, username, login, client, failed)
+	return login.result()
+}
 
-		tokenString, errCode, err := v.obtainValidToken(client)
-		if err != nil {
-			return "", errCode, fmt.Errorf("basic auth: %s", err)
+// validateBasic verifies the credentials with the driver and sets the result of the login
+func (v *Validator) validateBasic(ctx context.Context, login *pendingLogin, username, password string) {
+	valid, claims, err := v.basicDriver.ValidateBasic(ctx, username, password)
+	switch {
+	case err != nil:
+		login.errCode, login.err = http.StatusInternalServerError, fmt.Errorf("basic auth: validation error: %s", err)
+	case !valid && claims != nil && claims.Status != "":
+		login.errCode, login.err = http.StatusUnauthorized, fmt.Errorf("basic auth: %s", claims.Status)
+	case !valid:
+		login.errCode, login.err = http.StatusUnauthorized, fmt.Errorf("basic auth: invalid credentials")
+	default:
+		if claims == nil {
+			claims = &authz.Claims{Username: username}
 		}
+		login.errCode, login.claims = http.StatusOK, claims
+	}
+}
 
-		clients[credentials] = client
-		time.AfterFunc(clientExpiration, func() {
-			clientsMu.Lock()
-			delete(clients, credentials)
-			clientsMu.Unlock()
-		})
-
-		return tokenString, http.StatusOK, nil
+// obtainBasic obtains a token for the credentials and sets the result of the login
+//	It returns the client to renew the token.
+func (v *Validator) obtainBasic(ctx context.Context, login *pendingLogin, username, password string) *obtainer.Client {
+	client, err := obtainer.NewClientFromConf(obtainer.Conf{
+		Provider:    v.driverName,
+		ProviderURL: v.serverAddr,
+		ClientID:    v.clientID,
+		Username:    username,
+		Password:    password,
+		HTTPClient:  v.httpClient,
+	})
+	if err != nil {
+		login.errCode, login.err = http.StatusInternalServerError, fmt.Errorf("basic auth: unable to create a client to obtain tokens: %s", err)
+		return nil
+	}
+	login.tokenString, login.errCode, login.err = v.obtainValidToken(ctx, client)
+	if login.err != nil {
+		login.err = fmt.Errorf("basic auth: %s", login.err)
 	}
-	clientsMu.Unlock()
-	return v.obtainValidToken(client)
+	return client
 }
 
-func (v *Validator) obtainValidToken(client *obtainer.Client) (string, int, error) {
-	tokenString, err := client.Obtain()
+// obtainValidToken returns a valid token of the client
+//	The error code is 401 only if the provider rejected the credentials, and 502 or 503 if it failed or could not be
+//	reached, so that outages of the provider do not count as failed logins.
+func (v *Validator) obtainValidToken(ctx context.Context, client *obtainer.Client) (string, int, error) {
+	tokenString, err := client.ObtainContext(ctx)
 	if err != nil {
-		return "", http.StatusUnauthorized, fmt.Errorf("unable to obtain token: %s", err)
+		return "", obtainErrorCode(err), fmt.Errorf("unable to obtain token: %s", err)
 	}
 
-	valid, _, err := v.driver.Validate(v.serverAddr, v.clientID, tokenString)
+	valid, _, err := v.ValidateContext(ctx, tokenString)
 	if err != nil {
 		return "", http.StatusInternalServerError, fmt.Errorf("validation error: %s", err)
 	}
 	if !valid {
-		tokenString, err = client.Renew()
+		tokenString, err = client.RenewContext(ctx)
 		if err != nil {
-			return "", http.StatusUnauthorized, fmt.Errorf("unable to renew token: %s", err)
+			return "", obtainErrorCode(err), fmt.Errorf("unable to renew token: %s", err)
 		}
 	}
 	return tokenString, http.StatusOK, nil
 }
+
+// obtainErrorCode returns the status code for an error obtaining a token
+func obtainErrorCode(err error) int {
+	if obtainer.IsRejected(err) {
+		return http.StatusUnauthorized
+	}
+	var providerErr *obtainer.ProviderError
+	if errors.As(err, &providerErr) {
+		return http.StatusBadGateway
+	}
+	// e.g. the provider is unreachable or the request timed out
+	return http.StatusServiceUnavailable
+}
+
+// basicCache keeps the clients of Basic Authentication logins and throttles failed logins
+//	Logins are keyed by a salted hash of the credentials and only successful logins are kept. The cache is bounded
+//	and evicts the least recently used logins. Failed logins are counted per username: after maxFailures, further
+//	logins of the username are rejected until the lockout time has passed since the last failure. Cached logins are
+//	not affected, so that an attacker cannot lock out users who are already logged in. The failure counts are bounded
+//	like the logins, but locked out usernames are kept apart until their lockout ends, so that failed logins of
+//	other usernames cannot evict them. They are bounded by size too: when more usernames are locked out, the lockout
+//	that started first ends early.
+//	The cache is not locked while logging in at the provider.
+type basicCache struct {
+	size        int
+	ttl         time.Duration
+	maxFailures int
+	lockout     time.Duration
+	salt        []byte
+	now         func() time.Time
+
+	mu      sync.Mutex
+	logins  map[[sha256.Size]byte]*list.Element
+	lru     *list.List // most recently used at front
+	pending map[[sha256.Size]byte]*pendingLogin
+	// failures are bounded by size as well
+	failures   map[string]*list.Element
+	failureLRU *list.List
+	// locked are the usernames that reached maxFailures, with the time of the last failure
+	locked    map[string]*list.Element
+	lockedLRU *list.List // most recently locked at front
+}
+
+// loginEntry is a successful login, with either the client to obtain tokens or the claims verified by the driver
+type loginEntry struct {
+	key     [sha256.Size]byte
+	client  *obtainer.Client
+	claims  *authz.Claims
+	expires time.Time
+}
+
+type failureEntry struct {
+	username string
+	count    int
+	last     time.Time
+}
+
+// pendingLogin is a login in progress, whose result is shared with concurrent requests of the same credentials
+type pendingLogin struct {
+	done        chan struct{}
+	tokenString string
+	claims      *authz.Claims
+	errCode     int
+	err         error
+}
+
+// result returns the result of the login, with a copy of the claims
+func (l *pendingLogin) result() (string, *authz.Claims, int, error) {
+	if l.claims != nil {
+		return "", copyClaims(l.claims), l.errCode, l.err
+	}
+	return l.tokenString, nil, l.errCode, l.err
+}
+
+// newBasicCache returns a cache configured with the given configuration, using defaults for unset values
+func newBasicCache(conf BasicAuthConf) (*basicCache, error) {
+	c := &basicCache{
+		size:        conf.CacheSize,
+		ttl:         time.Duration(conf.CacheTTL) * time.Second,
+		maxFailures: conf.MaxFailures,
+		lockout:     time.Duration(conf.LockoutTime) * time.Second,
+		salt:        make([]byte, 32),
+		now:         time.Now,
+		logins:      make(map[[sha256.Size]byte]*list.Element),
+		lru:         list.New(),
+		pending:     make(map[[sha256.Size]byte]*pendingLogin),
+		failures:    make(map[string]*list.Element),
+		failureLRU:  list.New(),
+		locked:      make(map[string]*list.Element),
+		lockedLRU:   list.New(),
+	}
+	if c.size == 0 {
+		c.size = DefaultBasicCacheSize
+	}
+	if c.ttl == 0 {
+		c.ttl = DefaultBasicCacheTTL * time.Second
+	}
+	if c.maxFailures == 0 {
+		c.maxFailures = DefaultBasicMaxFailures
+	}
+	if c.lockout == 0 {
+		c.lockout = DefaultBasicLockoutTime * time.Second
+	}
+	if _, err := rand.Read(c.salt); err != nil {
+		return nil, fmt.Errorf("error generating salt: %s", err)
+	}
+	return c, nil
+}
+
+// key returns the salted hash of the credentials
+func (c *basicCache) key(credentials []byte) [sha256.Size]byte {
+	var key [sha256.Size]byte
+	mac := hmac.New(sha256.New, c.salt)
+	mac.Write(credentials)
+	copy(key[:], mac.Sum(nil))
+	return key
+}
+
+// get returns a cached login
+func (c *basicCache) get(key [sha256.Size]byte) (*loginEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, found := c.logins[key]
+	if !found {
+		return nil, false
+	}
+	entry := elem.Value.(*loginEntry)
+	if !c.now().Before(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.logins, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry, true
+}
+
+// remove removes a cached login
+func (c *basicCache) remove(key [sha256.Size]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, found := c.logins[key]; found {
+		c.lru.Remove(elem)
+		delete(c.logins, key)
+	}
+}
+
+// begin returns the pending login of the credentials, which is new if first is true
+//	It returns throttled if the username has too many failed logins.
+func (c *basicCache) begin(key [sha256.Size]byte, username string) (login *pendingLogin, first, throttled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if login, found := c.pending[key]; found {
+		return login, false, false
+	}
+	c.unlockExpired()
+	if _, found := c.locked[username]; found {
+		return nil, false, true
+	}
+	if elem, found := c.failures[username]; found {
+		if c.now().Sub(elem.Value.(*failureEntry).last) >= c.lockout {
+			c.failureLRU.Remove(elem)
+			delete(c.failures, username)
+		}
+	}
+	login = &pendingLogin{done: make(chan struct{})}
+	c.pending[key] = login
+	return login, true, false
+}
+
+// end completes the pending login, caching it if successful and counting the failure otherwise
+func (c *basicCache) end(key [sha256.Size]byte, username string, login *pendingLogin, client *obtainer.Client, failed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.pending, key)
+	close(login.done)
+
+	if login.err == nil {
+		if elem, found := c.failures[username]; found {
+			c.failureLRU.Remove(elem)
+			delete(c.failures, username)
+		}
+		if elem, found := c.locked[username]; found {
+			c.unlock(elem)
+		}
+		c.logins[key] = c.lru.PushFront(&loginEntry{key: key, client: client, claims: login.claims, expires: c.now().Add(c.ttl)})
+		for c.lru.Len() > c.size {
+			oldest := c.lru.Back()
+			c.lru.Remove(oldest)
+			delete(c.logins, oldest.Value.(*loginEntry).key)
+		}
+		return
+	}
+	if !failed {
+		return
+	}
+
+	elem, found := c.failures[username]
+	if !found {
+		elem = c.failureLRU.PushFront(&failureEntry{username: username})
+		c.failures[username] = elem
+		for c.failureLRU.Len() > c.size {
+			oldest := c.failureLRU.Back()
+			c.failureLRU.Remove(oldest)
+			delete(c.failures, oldest.Value.(*failureEntry).username)
+		}
+	}
+	entry := elem.Value.(*failureEntry)
+	entry.count++
+	entry.last = c.now()
+	c.failureLRU.MoveToFront(elem)
+
+	if entry.count >= c.maxFailures {
+		c.failureLRU.Remove(elem)
+		delete(c.failures, username)
+		c.lock(username, entry.last)
+	}
+}
+
+// lock locks out the username, ending the lockout that started first if there are too many
+func (c *basicCache) lock(username string, last time.Time) {
+	if elem, found := c.locked[username]; found {
+		c.lockedLRU.Remove(elem)
+	}
+	c.locked[username] = c.lockedLRU.PushFront(&failureEntry{username: username, count: c.maxFailures, last: last})
+	for c.lockedLRU.Len() > c.size {
+		c.unlock(c.lockedLRU.Back())
+	}
+}
+
+// unlockExpired removes the usernames whose lockout has ended, which are the oldest ones
+func (c *basicCache) unlockExpired() {
+	for elem := c.lockedLRU.Back(); elem != nil && c.now().Sub(elem.Value.(*failureEntry).last) >= c.lockout; elem = c.lockedLRU.Back() {
+		c.unlock(elem)
+	}
+}
+
+// unlock removes a locked out username
+func (c *basicCache) unlock(elem *list.Element) {
+	c.lockedLRU.Remove(elem)
+	delete(c.locked, elem.Value.(*failureEntry).username)
+}
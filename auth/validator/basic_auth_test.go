@@ -0,0 +1,273 @@
+package validator
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/linksmart/go-sec/auth/obtainer"
+	"github.com/linksmart/go-sec/authz"
+)
+
+const basicTestDriver = "basic-test"
+
+// basicDriver is an obtainer and validator driver for users with the password "secret"
+//	Logins of users in the blocked map wait until their channel is closed, and logins of users in the down map fail
+//	as if the provider was unreachable.
+type basicDriver struct {
+	sync.Mutex
+	blocked map[string]chan struct{}
+	down    map[string]bool
+	obtains int
+}
+
+var testBasicDriver = &basicDriver{blocked: make(map[string]chan struct{}), down: make(map[string]bool)}
+
+func init() {
+	obtainer.Register(basicTestDriver, testBasicDriver)
+	Register(basicTestDriver, testBasicDriver)
+}
+
+func (d *basicDriver) ObtainToken(serverAddr, username, password, clientID string) (*obtainer.Token, error) {
+	d.Lock()
+	d.obtains++
+	block := d.blocked[username]
+	down := d.down[username]
+	d.Unlock()
+	if block != nil {
+		<-block
+	}
+	if down {
+		return nil, fmt.Errorf("connection refused")
+	}
+	if password != "secret" {
+		return nil, &obtainer.ProviderError{StatusCode: http.StatusUnauthorized, Code: "invalid_grant", Message: "invalid user credentials"}
+	}
+	return &obtainer.Token{AccessToken: "token-" + username}, nil
+}
+
+func (d *basicDriver) ObtainClientToken(serverAddr string, credentials obtainer.ClientCredentials) (*obtainer.Token, error) {
+	return nil, fmt.Errorf("not supported")
+}
+
+func (d *basicDriver) RenewToken(serverAddr string, token *obtainer.Token, credentials obtainer.ClientCredentials) (*obtainer.Token, error) {
+	return nil, fmt.Errorf("not supported")
+}
+
+func (d *basicDriver) RevokeToken(serverAddr string, token *obtainer.Token, credentials obtainer.ClientCredentials) error {
+	return nil
+}
+
+func (d *basicDriver) Validate(serverAddr, clientID, tokenString string) (bool, *authz.Claims, error) {
+	if !strings.HasPrefix(tokenString, "token-") {
+		return false, &authz.Claims{Status: "invalid token."}, nil
+	}
+	return true, &authz.Claims{Username: strings.TrimPrefix(tokenString, "token-")}, nil
+}
+
+func (d *basicDriver) count() int {
+	d.Lock()
+	defer d.Unlock()
+	return d.obtains
+}
+
+func (d *basicDriver) block(username string) chan struct{} {
+	d.Lock()
+	defer d.Unlock()
+	block := make(chan struct{})
+	d.blocked[username] = block
+	return block
+}
+
+func (d *basicDriver) setDown(username string, down bool) {
+	d.Lock()
+	defer d.Unlock()
+	d.down[username] = down
+}
+
+func setupBasic(t *testing.T, conf *BasicAuthConf) (*Validator, http.Handler) {
+	v, err := SetupFromConf(Conf{
+		Provider:     basicTestDriver,
+		ProviderURL:  "http://localhost",
+		ClientID:     "test-client",
+		BasicEnabled: true,
+		BasicAuth:    conf,
+	})
+	if err != nil {
+		t.Fatalf("Error setting up validator: %s", err)
+	}
+	return v, v.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+}
+
+func basicRequest(handler http.Handler, username, password string) int {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(username+":"+password)))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestBasicAuthCache(t *testing.T) {
+	_, handler := setupBasic(t, &BasicAuthConf{CacheSize: 2})
+
+	start := testBasicDriver.count()
+	for _, tc := range []struct {
+		username string
+		code     int
+		obtains  int
+	}{
+		{"alice", http.StatusOK, 1},
+		{"alice", http.StatusOK, 1},
+		{"bob", http.StatusOK, 2},
+		{"carol", http.StatusOK, 3}, // evicts alice
+		{"bob", http.StatusOK, 3},
+		{"alice", http.StatusOK, 4},
+	} {
+		if code := basicRequest(handler, tc.username, "secret"); code != tc.code {
+			t.Fatalf("%s: expected %d, got %d", tc.username, tc.code, code)
+		}
+		if obtains := testBasicDriver.count() - start; obtains != tc.obtains {
+			t.Fatalf("%s: expected %d obtains, got %d", tc.username, tc.obtains, obtains)
+		}
+	}
+}
+
+func TestBasicAuthThrottling(t *testing.T) {
+	v, handler := setupBasic(t, &BasicAuthConf{MaxFailures: 2, LockoutTime: 60})
+	clock := time.Now()
+	v.basicCache.now = func() time.Time { return clock }
+
+	// logged in before the attack
+	if code := basicRequest(handler, "alice", "secret"); code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d", http.StatusOK, code)
+	}
+	for i := 0; i < 2; i++ {
+		if code := basicRequest(handler, "alice", "guess"); code != http.StatusUnauthorized {
+			t.Fatalf("Wrong password: expected %d, got %d", http.StatusUnauthorized, code)
+		}
+	}
+	if code := basicRequest(handler, "alice", "guess"); code != http.StatusTooManyRequests {
+		t.Fatalf("Throttled: expected %d, got %d", http.StatusTooManyRequests, code)
+	}
+	// cached credentials are not locked out
+	if code := basicRequest(handler, "alice", "secret"); code != http.StatusOK {
+		t.Fatalf("Cached login: expected %d, got %d", http.StatusOK, code)
+	}
+	// other users are not affected
+	if code := basicRequest(handler, "bob", "secret"); code != http.StatusOK {
+		t.Fatalf("Other user: expected %d, got %d", http.StatusOK, code)
+	}
+
+	clock = clock.Add(time.Minute)
+	if code := basicRequest(handler, "alice", "guess"); code != http.StatusUnauthorized {
+		t.Fatalf("After lockout: expected %d, got %d", http.StatusUnauthorized, code)
+	}
+}
+
+func TestBasicAuthProviderDown(t *testing.T) {
+	_, handler := setupBasic(t, &BasicAuthConf{MaxFailures: 2, LockoutTime: 60})
+
+	// errors of the provider are not failed logins
+	testBasicDriver.setDown("dave", true)
+	for i := 0; i < 3; i++ {
+		if code := basicRequest(handler, "dave", "secret"); code != http.StatusServiceUnavailable {
+			t.Fatalf("Provider down: expected %d, got %d", http.StatusServiceUnavailable, code)
+		}
+	}
+	testBasicDriver.setDown("dave", false)
+	if code := basicRequest(handler, "dave", "secret"); code != http.StatusOK {
+		t.Fatalf("Provider up: expected %d, got %d", http.StatusOK, code)
+	}
+}
+
+func TestBasicAuthLockoutEviction(t *testing.T) {
+	_, handler := setupBasic(t, &BasicAuthConf{CacheSize: 2, MaxFailures: 2, LockoutTime: 60})
+
+	for i := 0; i < 2; i++ {
+		basicRequest(handler, "erin", "guess")
+	}
+	// failed logins of other usernames do not lift the lockout
+	for i := 0; i < 10; i++ {
+		basicRequest(handler, fmt.Sprintf("user%d", i), "guess")
+	}
+	if code := basicRequest(handler, "erin", "guess"); code != http.StatusTooManyRequests {
+		t.Fatalf("Throttled: expected %d, got %d", http.StatusTooManyRequests, code)
+	}
+}
+
+func TestBasicAuthLockoutBound(t *testing.T) {
+	v, handler := setupBasic(t, &BasicAuthConf{CacheSize: 2, MaxFailures: 1, LockoutTime: 60})
+	clock := time.Now()
+	v.basicCache.now = func() time.Time { return clock }
+
+	for _, username := range []string{"frank", "grace", "heidi"} {
+		basicRequest(handler, username, "guess")
+		clock = clock.Add(time.Second)
+	}
+	// the lockout that started first ends when too many usernames are locked out
+	if n := len(v.basicCache.locked); n != 2 {
+		t.Fatalf("Expected 2 locked out usernames, got %d", n)
+	}
+	if code := basicRequest(handler, "frank", "guess"); code != http.StatusUnauthorized {
+		t.Fatalf("Oldest lockout: expected %d, got %d", http.StatusUnauthorized, code)
+	}
+	if code := basicRequest(handler, "heidi", "guess"); code != http.StatusTooManyRequests {
+		t.Fatalf("Throttled: expected %d, got %d", http.StatusTooManyRequests, code)
+	}
+
+	// ended lockouts are removed on any login
+	clock = clock.Add(time.Minute)
+	basicRequest(handler, "ivan", "secret")
+	if n := len(v.basicCache.locked); n != 0 {
+		t.Fatalf("Expected no locked out usernames, got %d", n)
+	}
+}
+
+func TestBasicAuthConcurrent(t *testing.T) {
+	_, handler := setupBasic(t, nil)
+	block := testBasicDriver.block("slow")
+	start := testBasicDriver.count()
+
+	// concurrent logins of the same credentials share one login
+	var wg sync.WaitGroup
+	codes := make(chan int, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- basicRequest(handler, "slow", "secret")
+		}()
+	}
+
+	// other users are not blocked by the pending login
+	done := make(chan int)
+	go func() {
+		done <- basicRequest(handler, "fast", "secret")
+	}()
+	select {
+	case code := <-done:
+		if code != http.StatusOK {
+			t.Fatalf("Other user: expected %d, got %d", http.StatusOK, code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Login of other user was blocked by a pending login")
+	}
+
+	close(block)
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Fatalf("Expected %d, got %d", http.StatusOK, code)
+		}
+	}
+	if obtains := testBasicDriver.count() - start; obtains > 2 {
+		// one for fast, and one for slow unless a request arrived after the shared login ended
+		t.Fatalf("Expected 2 obtains, got %d", obtains)
+	}
+}
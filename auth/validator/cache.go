@@ -0,0 +1,163 @@
+package validator
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/linksmart/go-sec/authz"
+)
+
+// ResultCache is a bounded LRU cache of validation results, keyed by the token hash
+//	It is used by Validator when caching is enabled and can be used by drivers to cache results of remote validations.
+type ResultCache struct {
+	size        int
+	maxTTL      time.Duration
+	negativeTTL time.Duration
+	now         func() time.Time
+
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]*list.Element
+	lru     *list.List // most recently used at front
+}
+
+type cacheEntry struct {
+	key     [sha256.Size]byte
+	valid   bool
+	claims  *authz.Claims
+	expires time.Time
+}
+
+// NewResultCache returns a cache configured with the given configuration, using defaults for unset values
+func NewResultCache(conf CacheConf) *ResultCache {
+	c := &ResultCache{
+		size:        conf.Size,
+		maxTTL:      time.Duration(conf.MaxTTL) * time.Second,
+		negativeTTL: time.Duration(conf.NegativeTTL) * time.Second,
+		now:         time.Now,
+		entries:     make(map[[sha256.Size]byte]*list.Element),
+		lru:         list.New(),
+	}
+	if c.size == 0 {
+		c.size = DefaultCacheSize
+	}
+	if c.maxTTL == 0 {
+		c.maxTTL = DefaultCacheMaxTTL * time.Second
+	}
+	if c.negativeTTL == 0 {
+		c.negativeTTL = DefaultCacheNegativeTTL * time.Second
+	}
+	return c
+}
+
+// Get returns the cached result of the token
+//	The returned claims are a copy, which the caller may modify.
+func (c *ResultCache) Get(tokenString string) (valid bool, claims *authz.Claims, found bool) {
+	key := sha256.Sum256([]byte(tokenString))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, found := c.entries[key]
+	if !found {
+		return false, nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if !c.now().Before(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return false, nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry.valid, copyClaims(entry.claims), true
+}
+
+// Add caches the result of the token, evicting the least recently used result when full
+//	The claims are copied, so that the caller may modify them afterwards.
+//	Valid results are cached until the token expires (exp claim), but at most MaxTTL.
+func (c *ResultCache) Add(tokenString string, valid bool, claims *authz.Claims) {
+	now := c.now()
+	expires := now.Add(c.negativeTTL)
+	if valid {
+		expires = now.Add(c.maxTTL)
+		if claims != nil {
+			if exp, ok := expiry(claims.Extra); ok && exp.Before(expires) {
+				expires = exp
+			}
+		}
+		if !now.Before(expires) {
+			return
+		}
+	}
+	claims = copyClaims(claims)
+	key := sha256.Sum256([]byte(tokenString))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, found := c.entries[key]; found {
+		c.lru.Remove(elem)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, valid: valid, claims: claims, expires: expires})
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// copyClaims returns a deep copy of the claims, so that the copy can be modified without affecting the original
+func copyClaims(claims *authz.Claims) *authz.Claims {
+	if claims == nil {
+		return nil
+	}
+	copied := *claims
+	if claims.Groups != nil {
+		copied.Groups = append([]string(nil), claims.Groups...)
+	}
+	if claims.Roles != nil {
+		copied.Roles = append([]string(nil), claims.Roles...)
+	}
+	if claims.Extra != nil {
+		copied.Extra = copyValue(claims.Extra).(map[string]interface{})
+	}
+	return &copied
+}
+
+// copyValue returns a deep copy of a claim value, e.g. as decoded from JSON
+func copyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for name, value := range v {
+			copied[name] = copyValue(value)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, value := range v {
+			copied[i] = copyValue(value)
+		}
+		return copied
+	case []string:
+		return append([]string(nil), v...)
+	}
+	return v
+}
+
+// expiry returns the expiration time given in the exp claim
+func expiry(claims map[string]interface{}) (time.Time, bool) {
+	switch exp := claims["exp"].(type) {
+	case float64:
+		return time.Unix(int64(exp), 0), true
+	case int64:
+		return time.Unix(exp, 0), true
+	case json.Number:
+		if v, err := exp.Int64(); err == nil {
+			return time.Unix(v, 0), true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,140 @@
+package validator
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/linksmart/go-sec/authz"
+)
+
+// countingDriver accepts the tokens in its map and counts the validations
+type countingDriver struct {
+	sync.Mutex
+	valid       map[string]int64 // expiry by token
+	validations int
+}
+
+func (d *countingDriver) Validate(serverAddr, clientID, tokenString string) (bool, *authz.Claims, error) {
+	d.Lock()
+	defer d.Unlock()
+	d.validations++
+	exp, ok := d.valid[tokenString]
+	if !ok {
+		return false, &authz.Claims{Status: "invalid token."}, nil
+	}
+	return true, &authz.Claims{Username: "john", Extra: map[string]interface{}{"exp": float64(exp)}}, nil
+}
+
+func (d *countingDriver) count() int {
+	d.Lock()
+	defer d.Unlock()
+	return d.validations
+}
+
+func TestValidateCache(t *testing.T) {
+	now := time.Now()
+	driver := &countingDriver{valid: map[string]int64{
+		"long":  now.Add(time.Hour).Unix(),
+		"short": now.Add(30 * time.Second).Unix(),
+	}}
+	Register("counting", driver)
+	v, err := SetupFromConf(Conf{
+		Provider:    "counting",
+		ProviderURL: "http://localhost",
+		ClientID:    "test-client",
+		Cache:       &CacheConf{Size: 2},
+	})
+	if err != nil {
+		t.Fatalf("Error setting up validator: %s", err)
+	}
+	clock := now
+	v.cache.now = func() time.Time { return clock }
+
+	validate := func(token string, expectValid bool, expectValidations int) {
+		t.Helper()
+		valid, claims, err := v.Validate(token)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if valid != expectValid {
+			t.Fatalf("%s: expected valid=%t, got %t", token, expectValid, valid)
+		}
+		if valid && claims.Username != "john" {
+			t.Fatalf("%s: unexpected claims: %+v", token, claims)
+		}
+		if n := driver.count(); n != expectValidations {
+			t.Fatalf("%s: expected %d validations, got %d", token, expectValidations, n)
+		}
+	}
+
+	validate("long", true, 1)
+	validate("long", true, 1)
+	validate("invalid", false, 2)
+	validate("invalid", false, 2)
+
+	// negative results expire after NegativeTTL
+	clock = now.Add(DefaultCacheNegativeTTL * time.Second)
+	validate("invalid", false, 3)
+
+	// valid results expire with the token
+	validate("short", true, 4) // evicts "long"
+	validate("short", true, 4)
+	clock = now.Add(30 * time.Second)
+	validate("short", true, 5)
+	validate("long", true, 6)
+
+	// valid results expire after MaxTTL
+	clock = now.Add(DefaultCacheMaxTTL*time.Second + 31*time.Second)
+	validate("long", true, 7)
+
+	// cached claims are not shared
+	_, claims, _ := v.Validate("long")
+	claims.Username = "mallory"
+	validate("long", true, 7)
+}
+
+func TestResultCacheCopies(t *testing.T) {
+	cache := NewResultCache(CacheConf{})
+	claims := &authz.Claims{
+		Username: "john",
+		Groups:   []string{"admin"},
+		Roles:    []string{"editor"},
+		Extra: map[string]interface{}{
+			"exp":          float64(time.Now().Add(time.Hour).Unix()),
+			"realm_access": map[string]interface{}{"roles": []interface{}{"offline_access"}},
+		},
+	}
+	cache.Add("token", true, claims)
+
+	// modifying the added claims does not affect the cache
+	claims.Groups[0] = "modified"
+	claims.Extra["realm_access"].(map[string]interface{})["roles"].([]interface{})[0] = "modified"
+
+	modify := func() {
+		_, cached, _ := cache.Get("token")
+		cached.Groups[0] = "modified"
+		cached.Roles = append(cached.Roles[:0], "modified")
+		cached.Extra["tenant"] = "modified"
+		cached.Extra["realm_access"].(map[string]interface{})["roles"].([]interface{})[0] = "modified"
+	}
+	// modifying the returned claims does not affect the cache, also concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			modify()
+		}()
+	}
+	wg.Wait()
+
+	_, cached, found := cache.Get("token")
+	if !found {
+		t.Fatalf("Result not cached")
+	}
+	if cached.Groups[0] != "admin" || cached.Roles[0] != "editor" || cached.Extra["tenant"] != nil ||
+		cached.Extra["realm_access"].(map[string]interface{})["roles"].([]interface{})[0] != "offline_access" {
+		t.Fatalf("Cached claims were modified: %+v", cached)
+	}
+}
@@ -0,0 +1,140 @@
+package validator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/linksmart/go-sec/authz"
+)
+
+// ClaimsMapping maps token claims onto the fields of authz.Claims
+//	Each field lists the paths of claims, with nested claims separated by dots (e.g. realm_access.roles).
+//	The placeholder {clientID} in paths is replaced with the configured client ID (e.g. resource_access.{clientID}.roles).
+//	Username and ClientID take the first non-empty claim, Groups and Roles take the values of all listed claims.
+//	Fields that are not set keep the value given by the validator driver.
+type ClaimsMapping struct {
+	// Preset is the name of a predefined mapping, used for the fields that are not set. See ClaimsMappingPresets.
+	Preset   string   `json:"preset"`
+	Username []string `json:"username"`
+	Groups   []string `json:"groups"`
+	Roles    []string `json:"roles"`
+	ClientID []string `json:"clientID"`
+}
+
+// ClaimsMappingPresets are the predefined claims mappings
+var ClaimsMappingPresets = map[string]ClaimsMapping{
+	// go-sec is the mapping expected by default, requiring protocol mappers for groups, roles, and clientID in Keycloak
+	"go-sec": {
+		Username: []string{"preferred_username"},
+		Groups:   []string{"groups"},
+		Roles:    []string{"roles"},
+		ClientID: []string{"clientID"},
+	},
+	// keycloak is the mapping of the claims that Keycloak issues by default
+	"keycloak": {
+		Username: []string{"preferred_username"},
+		Groups:   []string{"groups"},
+		Roles:    []string{"realm_access.roles", "resource_access.{clientID}.roles"},
+		ClientID: []string{"client_id", "clientId"},
+	},
+}
+
+// Validate validates the claims mapping
+func (m ClaimsMapping) Validate() error {
+	if _, found := ClaimsMappingPresets[m.Preset]; m.Preset != "" && !found {
+		return fmt.Errorf("unknown claims mapping preset: %s", m.Preset)
+	}
+	return nil
+}
+
+// Apply sets the fields of claims from the claims in Extra, given the client ID for placeholders
+func (m ClaimsMapping) Apply(claims *authz.Claims, clientID string) {
+	m.resolve(clientID).apply(claims)
+}
+
+// resolve returns the mapping with the preset applied and placeholders replaced
+func (m ClaimsMapping) resolve(clientID string) ClaimsMapping {
+	preset := ClaimsMappingPresets[m.Preset]
+	resolved := ClaimsMapping{
+		Username: m.Username,
+		Groups:   m.Groups,
+		Roles:    m.Roles,
+		ClientID: m.ClientID,
+	}
+	if len(resolved.Username) == 0 {
+		resolved.Username = preset.Username
+	}
+	if len(resolved.Groups) == 0 {
+		resolved.Groups = preset.Groups
+	}
+	if len(resolved.Roles) == 0 {
+		resolved.Roles = preset.Roles
+	}
+	if len(resolved.ClientID) == 0 {
+		resolved.ClientID = preset.ClientID
+	}
+
+	replacer := strings.NewReplacer("{clientID}", clientID)
+	for _, paths := range []*[]string{&resolved.Username, &resolved.Groups, &resolved.Roles, &resolved.ClientID} {
+		replaced := make([]string, len(*paths))
+		for i, path := range *paths {
+			replaced[i] = replacer.Replace(path)
+		}
+		*paths = replaced
+	}
+	return resolved
+}
+
+// apply sets the fields of claims from the claims in Extra
+func (m ClaimsMapping) apply(claims *authz.Claims) {
+	if claims == nil || claims.Extra == nil {
+		return
+	}
+	if len(m.Username) != 0 {
+		claims.Username = firstString(claims.Extra, m.Username)
+	}
+	if len(m.Groups) != 0 {
+		claims.Groups = allStrings(claims.Extra, m.Groups)
+	}
+	if len(m.Roles) != 0 {
+		claims.Roles = allStrings(claims.Extra, m.Roles)
+	}
+	if len(m.ClientID) != 0 {
+		claims.ClientID = firstString(claims.Extra, m.ClientID)
+	}
+}
+
+// firstString returns the first non-empty string claim from the given paths
+func firstString(claims map[string]interface{}, paths []string) string {
+	for _, path := range paths {
+		if v, found := authz.LookupClaim(claims, path); found {
+			if s, ok := v.(string); ok && s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// allStrings returns the string values of all claims from the given paths
+//	A claim is either a string or an array of strings.
+func allStrings(claims map[string]interface{}, paths []string) []string {
+	var values []string
+	for _, path := range paths {
+		v, found := authz.LookupClaim(claims, path)
+		if !found {
+			continue
+		}
+		switch v := v.(type) {
+		case string:
+			values = append(values, v)
+		case []interface{}:
+			for _, e := range v {
+				if s, ok := e.(string); ok {
+					values = append(values, s)
+				}
+			}
+		}
+	}
+	return values
+}
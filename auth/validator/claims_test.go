@@ -0,0 +1,198 @@
+package validator
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/linksmart/go-sec/authz"
+)
+
+// keycloakClaims are the claims of a token issued by Keycloak, decoded like the validator drivers do
+func keycloakClaims(t *testing.T) map[string]interface{} {
+	var claims map[string]interface{}
+	err := json.Unmarshal([]byte(`{
+		"preferred_username": "john",
+		"groups": ["admin", "user"],
+		"realm_access": {"roles": ["offline_access"]},
+		"resource_access": {
+			"test-client": {"roles": ["editor"]},
+			"other-client": {"roles": ["owner"]}
+		},
+		"clientId": "service",
+		"https://example.com/tenant": "acme",
+		"org.id": "flat",
+		"org": {"id": "nested", "name": "ACME"},
+		"exp": 1600000000
+	}`), &claims)
+	if err != nil {
+		t.Fatalf("Error decoding claims: %s", err)
+	}
+	return claims
+}
+
+func TestClaimsMappingPresets(t *testing.T) {
+	t.Run("go-sec", func(t *testing.T) {
+		claims := &authz.Claims{Extra: map[string]interface{}{
+			"preferred_username": "john",
+			"groups":             []interface{}{"admin"},
+			"roles":              []interface{}{"editor", "viewer"},
+			"clientID":           "tool",
+		}}
+		ClaimsMapping{Preset: "go-sec"}.Apply(claims, "test-client")
+		if claims.Username != "john" || claims.ClientID != "tool" ||
+			!reflect.DeepEqual(claims.Groups, []string{"admin"}) || !reflect.DeepEqual(claims.Roles, []string{"editor", "viewer"}) {
+			t.Fatalf("Unexpected claims: %+v", claims)
+		}
+	})
+
+	t.Run("keycloak", func(t *testing.T) {
+		claims := &authz.Claims{Extra: keycloakClaims(t)}
+		ClaimsMapping{Preset: "keycloak"}.Apply(claims, "test-client")
+		if claims.Username != "john" || !reflect.DeepEqual(claims.Groups, []string{"admin", "user"}) {
+			t.Fatalf("Unexpected claims: %+v", claims)
+		}
+		// realm roles and the roles of the configured client only
+		if !reflect.DeepEqual(claims.Roles, []string{"offline_access", "editor"}) {
+			t.Fatalf("Unexpected roles: %v", claims.Roles)
+		}
+		// clientId is the fallback of client_id
+		if claims.ClientID != "service" {
+			t.Fatalf("Unexpected client ID: %s", claims.ClientID)
+		}
+		claims.Extra["client_id"] = "service2"
+		ClaimsMapping{Preset: "keycloak"}.Apply(claims, "test-client")
+		if claims.ClientID != "service2" {
+			t.Fatalf("Unexpected client ID: %s", claims.ClientID)
+		}
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		if err := (ClaimsMapping{Preset: "other"}).Validate(); err == nil {
+			t.Fatalf("Expected error for unknown preset")
+		}
+		for preset := range ClaimsMappingPresets {
+			if err := (ClaimsMapping{Preset: preset}).Validate(); err != nil {
+				t.Fatalf("Unexpected error for preset %s: %s", preset, err)
+			}
+		}
+	})
+}
+
+func TestClaimsMappingApply(t *testing.T) {
+	t.Run("placeholder", func(t *testing.T) {
+		claims := &authz.Claims{Extra: keycloakClaims(t)}
+		ClaimsMapping{Roles: []string{"resource_access.{clientID}.roles"}}.Apply(claims, "other-client")
+		if !reflect.DeepEqual(claims.Roles, []string{"owner"}) {
+			t.Fatalf("Unexpected roles: %v", claims.Roles)
+		}
+	})
+
+	t.Run("paths", func(t *testing.T) {
+		claims := &authz.Claims{Extra: keycloakClaims(t)}
+		ClaimsMapping{Username: []string{"https://example.com/tenant"}, Groups: []string{"realm_access.roles"}, ClientID: []string{"org.name"}}.Apply(claims, "")
+		if claims.Username != "acme" || claims.ClientID != "ACME" || !reflect.DeepEqual(claims.Groups, []string{"offline_access"}) {
+			t.Fatalf("Unexpected claims: %+v", claims)
+		}
+
+		// a claim named with dots takes precedence over nested claims
+		ClaimsMapping{Username: []string{"org.id"}}.Apply(claims, "")
+		if claims.Username != "flat" {
+			t.Fatalf("Unexpected username: %s", claims.Username)
+		}
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		claims := &authz.Claims{Extra: keycloakClaims(t)}
+		// missing, empty, and non-string claims are skipped
+		claims.Extra["nickname"] = ""
+		ClaimsMapping{Username: []string{"missing", "exp", "nickname", "groups", "preferred_username"}}.Apply(claims, "")
+		if claims.Username != "john" {
+			t.Fatalf("Unexpected username: %s", claims.Username)
+		}
+
+		claims.Extra["mixed"] = []interface{}{"a", 1.0, true, "b"}
+		ClaimsMapping{Groups: []string{"missing", "mixed", "preferred_username", "exp"}}.Apply(claims, "")
+		if !reflect.DeepEqual(claims.Groups, []string{"a", "b", "john"}) {
+			t.Fatalf("Unexpected groups: %v", claims.Groups)
+		}
+
+		// no claim found
+		ClaimsMapping{Username: []string{"missing"}, Roles: []string{"missing"}}.Apply(claims, "")
+		if claims.Username != "" || claims.Roles != nil {
+			t.Fatalf("Unexpected claims: %+v", claims)
+		}
+	})
+
+	t.Run("unset fields", func(t *testing.T) {
+		claims := &authz.Claims{Username: "driver", Groups: []string{"driver"}, Roles: []string{"driver"}, ClientID: "driver",
+			Extra: keycloakClaims(t)}
+		ClaimsMapping{Username: []string{"preferred_username"}}.Apply(claims, "")
+		if claims.Username != "john" || claims.Groups[0] != "driver" || claims.Roles[0] != "driver" || claims.ClientID != "driver" {
+			t.Fatalf("Unexpected claims: %+v", claims)
+		}
+
+		// the preset is used for the fields that are not set
+		ClaimsMapping{Preset: "keycloak", Groups: []string{"realm_access.roles"}}.Apply(claims, "test-client")
+		if !reflect.DeepEqual(claims.Groups, []string{"offline_access"}) || !reflect.DeepEqual(claims.Roles, []string{"offline_access", "editor"}) {
+			t.Fatalf("Unexpected claims: %+v", claims)
+		}
+	})
+
+	t.Run("no extra", func(t *testing.T) {
+		ClaimsMapping{Preset: "go-sec"}.Apply(nil, "")
+		claims := &authz.Claims{Username: "driver"}
+		ClaimsMapping{Preset: "go-sec"}.Apply(claims, "")
+		if claims.Username != "driver" {
+			t.Fatalf("Unexpected username: %s", claims.Username)
+		}
+	})
+}
+
+// extraDriver accepts any token and returns the claims of a Keycloak token
+type extraDriver struct {
+	claims map[string]interface{}
+}
+
+func (d *extraDriver) Validate(serverAddr, clientID, tokenString string) (bool, *authz.Claims, error) {
+	return true, &authz.Claims{Username: "unmapped", Extra: d.claims}, nil
+}
+
+func TestValidatorClaimsMapping(t *testing.T) {
+	Register("extra", &extraDriver{claims: keycloakClaims(t)})
+
+	if _, err := SetupFromConf(Conf{
+		Provider:      "extra",
+		ProviderURL:   "http://localhost",
+		ClientID:      "test-client",
+		ClaimsMapping: &ClaimsMapping{Preset: "other"},
+	}); err == nil || !strings.Contains(err.Error(), "preset") {
+		t.Fatalf("Expected error for unknown preset, got %v", err)
+	}
+
+	v, err := SetupFromConf(Conf{
+		Provider:      "extra",
+		ProviderURL:   "http://localhost",
+		ClientID:      "test-client",
+		ClaimsMapping: &ClaimsMapping{Preset: "keycloak"},
+		Authz: authz.Conf{Enabled: true, Rules: authz.Rules{
+			{Paths: []string{"/docs"}, Methods: []string{"GET"}, Roles: []string{"editor"}},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Error setting up validator: %s", err)
+	}
+	valid, claims, err := v.Validate("token")
+	if err != nil || !valid {
+		t.Fatalf("Unexpected validation result: %v %v", valid, err)
+	}
+	if claims.Username != "john" || !reflect.DeepEqual(claims.Roles, []string{"offline_access", "editor"}) {
+		t.Fatalf("Unexpected claims: %+v", claims)
+	}
+
+	// the mapped roles are authorized
+	if code, err := v.authorize(claims, "/docs", "GET"); err != nil {
+		t.Fatalf("Mapped claims not authorized: %d %s", code, err)
+	}
+}
@@ -1,9 +1,13 @@
 package validator
 
 import (
+	"encoding/json"
 	"errors"
+	"net/http"
 	"net/url"
 
+	"github.com/linksmart/go-sec/auth/httpclient"
+	"github.com/linksmart/go-sec/auth/jose"
 	"github.com/linksmart/go-sec/authz"
 )
 
@@ -17,8 +21,30 @@ type Conf struct {
 	ProviderURL string `json:"providerURL"`
 	// ClientID is the authentication client id
 	ClientID string `json:"clientID"`
+	// ClientSecret is the authentication client secret, for providers that require client authentication (optional)
+	ClientSecret string `json:"clientSecret"`
 	// BasicEnabled toggles the Basic Authentication
 	BasicEnabled bool `json:"basicEnabled"`
+	// BasicAuth configures the cache and throttling of Basic Authentication logins (optional)
+	BasicAuth *BasicAuthConf `json:"basicAuth"`
+	// Algorithms are the accepted token signing algorithms, e.g. RS256, PS256, ES256, EdDSA (optional)
+	//	When not set, the default algorithms of the provider are accepted.
+	Algorithms []string `json:"algorithms"`
+	// StaticKeys are the trusted keys, for providers that do not publish their keys (optional)
+	StaticKeys *StaticKeysConf `json:"staticKeys"`
+	// Cache configures caching of validation results (optional)
+	Cache *CacheConf `json:"cache"`
+	// ClaimsMapping maps the token claims onto the user, groups, roles, and client (optional)
+	ClaimsMapping *ClaimsMapping `json:"claimsMapping"`
+	// Realm is the realm of the authentication challenges in WWW-Authenticate headers (default: the client ID)
+	Realm string `json:"realm"`
+	// ErrorFormat is the format of error responses: json (default) or problem (application/problem+json, RFC 7807)
+	ErrorFormat string `json:"errorFormat"`
+	// Transport configures the HTTP client for requests to the provider, e.g. to trust a private CA (optional)
+	Transport *httpclient.Conf `json:"transport"`
+	// HTTPClient is the HTTP client for requests to the provider (optional)
+	//	It takes precedence over Transport. If neither is set, http.DefaultClient is used.
+	HTTPClient *http.Client `json:"-"`
 	// Authz is the authorization config
 	Authz authz.Conf `json:"authorization"`
 }
@@ -45,6 +71,53 @@ func (c Conf) Validate() error {
 		return errors.New("auth client ID is not specified")
 	}
 
+	// Validate Algorithms
+	if err := jose.ValidateAlgorithms(c.Algorithms); err != nil {
+		return errors.New("algorithms: " + err.Error())
+	}
+
+	// Validate StaticKeys
+	if c.StaticKeys != nil {
+		if err := c.StaticKeys.Validate(); err != nil {
+			return errors.New("static keys: " + err.Error())
+		}
+	}
+
+	// Validate BasicAuth
+	if c.BasicAuth != nil {
+		if err := c.BasicAuth.Validate(); err != nil {
+			return errors.New("basic auth: " + err.Error())
+		}
+	}
+
+	// Validate Cache
+	if c.Cache != nil {
+		if err := c.Cache.Validate(); err != nil {
+			return errors.New("cache: " + err.Error())
+		}
+	}
+
+	// Validate ClaimsMapping
+	if c.ClaimsMapping != nil {
+		if err := c.ClaimsMapping.Validate(); err != nil {
+			return errors.New("claims mapping: " + err.Error())
+		}
+	}
+
+	// Validate ErrorFormat
+	switch c.ErrorFormat {
+	case "", ErrorFormatJSON, ErrorFormatProblem:
+	default:
+		return errors.New("error format is not supported: " + c.ErrorFormat)
+	}
+
+	// Validate Transport
+	if c.Transport != nil {
+		if err := c.Transport.Validate(); err != nil {
+			return errors.New("transport: " + err.Error())
+		}
+	}
+
 	// Validate Authorization
 	if c.Authz.Enabled {
 		if err := c.Authz.Validate(); err != nil {
@@ -54,3 +127,101 @@ func (c Conf) Validate() error {
 
 	return nil
 }
+
+// Error formats
+const (
+	// ErrorFormatJSON is a JSON object with the code and message of the error
+	ErrorFormatJSON = "json"
+	// ErrorFormatProblem is a problem details object (RFC 7807)
+	ErrorFormatProblem = "problem"
+)
+
+// StaticKeysConf are trusted keys given in files or inline
+type StaticKeysConf struct {
+	// PEMFiles are paths of PEM files with public keys or certificates
+	PEMFiles []string `json:"pemFiles"`
+	// JWKS is an inline JWK Set document
+	JWKS json.RawMessage `json:"jwks"`
+	// JWKSFile is the path of a JWK Set document
+	JWKSFile string `json:"jwksFile"`
+}
+
+// Validate validates the static keys configuration
+func (c StaticKeysConf) Validate() error {
+	if len(c.PEMFiles) == 0 && len(c.JWKS) == 0 && c.JWKSFile == "" {
+		return errors.New("no keys are specified")
+	}
+	return nil
+}
+
+// CacheConf configures the cache of token validation results
+//	Results of valid tokens are cached until the token expires, but at most MaxTTL.
+//	Results of invalid tokens are cached for NegativeTTL.
+type CacheConf struct {
+	// Size is the maximum number of cached results (default 1000)
+	Size int `json:"size"`
+	// MaxTTL is the maximum time in seconds to cache the result of a valid token (default 300)
+	MaxTTL int `json:"maxTTL"`
+	// NegativeTTL is the time in seconds to cache the result of an invalid token (default 10)
+	NegativeTTL int `json:"negativeTTL"`
+}
+
+// Default values of CacheConf
+const (
+	DefaultCacheSize        = 1000
+	DefaultCacheMaxTTL      = 300
+	DefaultCacheNegativeTTL = 10
+)
+
+// Validate validates the cache configuration
+func (c CacheConf) Validate() error {
+	if c.Size < 0 {
+		return errors.New("size must not be negative")
+	}
+	if c.MaxTTL < 0 {
+		return errors.New("maxTTL must not be negative")
+	}
+	if c.NegativeTTL < 0 {
+		return errors.New("negativeTTL must not be negative")
+	}
+	return nil
+}
+
+// BasicAuthConf configures Basic Authentication logins
+//	Successful logins are cached, so that tokens are not obtained for every request. After MaxFailures failed logins
+//	of a username, its logins are rejected until LockoutTime has passed since the last failure.
+type BasicAuthConf struct {
+	// CacheSize is the maximum number of cached logins (default 1000)
+	CacheSize int `json:"cacheSize"`
+	// CacheTTL is the time in seconds a login is cached (default 600)
+	CacheTTL int `json:"cacheTTL"`
+	// MaxFailures is the number of failed logins of a username before its logins are rejected (default 5)
+	MaxFailures int `json:"maxFailures"`
+	// LockoutTime is the time in seconds after the last failed login until logins of the username are accepted again (default 60)
+	LockoutTime int `json:"lockoutTime"`
+}
+
+// Default values of BasicAuthConf
+const (
+	DefaultBasicCacheSize   = 1000
+	DefaultBasicCacheTTL    = 600
+	DefaultBasicMaxFailures = 5
+	DefaultBasicLockoutTime = 60
+)
+
+// Validate validates the basic auth configuration
+func (c BasicAuthConf) Validate() error {
+	if c.CacheSize < 0 {
+		return errors.New("cacheSize must not be negative")
+	}
+	if c.CacheTTL < 0 {
+		return errors.New("cacheTTL must not be negative")
+	}
+	if c.MaxFailures < 0 {
+		return errors.New("maxFailures must not be negative")
+	}
+	if c.LockoutTime < 0 {
+		return errors.New("lockoutTime must not be negative")
+	}
+	return nil
+}
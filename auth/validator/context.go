@@ -0,0 +1,37 @@
+package validator
+
+import (
+	"context"
+
+	"github.com/linksmart/go-sec/authz"
+)
+
+// contextKey is the type of the keys of values stored in the request context
+type contextKey int
+
+const (
+	claimsKey contextKey = iota
+	tokenKey
+)
+
+// NewContext returns a copy of the context carrying the validated claims and the raw token
+//	Handler stores them in the request context before calling the next handler.
+func NewContext(ctx context.Context, claims *authz.Claims, tokenString string) context.Context {
+	ctx = context.WithValue(ctx, claimsKey, claims)
+	return context.WithValue(ctx, tokenKey, tokenString)
+}
+
+// ClaimsFromContext returns the validated claims of the requester
+//	It returns false for anonymous requests.
+func ClaimsFromContext(ctx context.Context) (*authz.Claims, bool) {
+	claims, ok := ctx.Value(claimsKey).(*authz.Claims)
+	return claims, ok && claims != nil
+}
+
+// TokenFromContext returns the raw token of the requester
+//	For Basic authentication, this is the token obtained for the given credentials.
+//	It returns false for anonymous requests and when the driver verified Basic credentials itself (see BasicDriver).
+func TokenFromContext(ctx context.Context) (string, bool) {
+	tokenString, ok := ctx.Value(tokenKey).(string)
+	return tokenString, ok && tokenString != ""
+}
@@ -0,0 +1,309 @@
+package validator
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/linksmart/go-sec/authz"
+)
+
+func TestHandlerErrors(t *testing.T) {
+	v, err := SetupFromConf(Conf{
+		Provider:     basicTestDriver,
+		ProviderURL:  "http://localhost",
+		ClientID:     "test-client",
+		BasicEnabled: true,
+		Realm:        "example",
+		Authz: authz.Conf{
+			Enabled: true,
+			Rules:   []authz.Rule{{Paths: []string{"/data"}, Methods: []string{"GET"}, Users: []string{"alice"}}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error setting up validator: %s", err)
+	}
+	handler := v.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, tc := range []struct {
+		name          string
+		authorization string
+		code          int
+		challenges    []string
+	}{
+		{"no credentials", "", http.StatusUnauthorized, []string{
+			`Bearer realm="example"`,
+			`Basic realm="example", charset="UTF-8"`,
+		}},
+		{"invalid header", "Bearer", http.StatusBadRequest, []string{
+			`Bearer realm="example", error="invalid_request", error_description="invalid format for Authorization header value"`,
+		}},
+		{"invalid token", "Bearer invalid", http.StatusUnauthorized, []string{
+			`Bearer realm="example", error="invalid_token", error_description="unauthorized request: invalid token."`,
+			`Basic realm="example", charset="UTF-8"`,
+		}},
+		{"insufficient scope", "Bearer token-bob", http.StatusForbidden, []string{
+			`Bearer realm="example", error="insufficient_scope", error_description="access forbidden"`,
+		}},
+		{"basic forbidden", "Basic Ym9iOnNlY3JldA==", http.StatusForbidden, nil},
+		{"authorized", "Bearer token-alice", http.StatusOK, nil},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/data", nil)
+		if tc.authorization != "" {
+			r.Header.Set("Authorization", tc.authorization)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != tc.code {
+			t.Fatalf("%s: expected %d, got %d: %s", tc.name, tc.code, w.Code, w.Body)
+		}
+		challenges := w.Header()["Www-Authenticate"]
+		if len(challenges) != len(tc.challenges) {
+			t.Fatalf("%s: expected challenges %q, got %q", tc.name, tc.challenges, challenges)
+		}
+		for i := range challenges {
+			if challenges[i] != tc.challenges[i] {
+				t.Fatalf("%s: expected challenge %s, got %s", tc.name, tc.challenges[i], challenges[i])
+			}
+		}
+	}
+}
+
+func TestHandlerProblem(t *testing.T) {
+	v, err := SetupFromConf(Conf{
+		Provider:    basicTestDriver,
+		ProviderURL: "http://localhost",
+		ClientID:    "test-client",
+		ErrorFormat: ErrorFormatProblem,
+	})
+	if err != nil {
+		t.Fatalf("Error setting up validator: %s", err)
+	}
+	handler := v.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	r := httptest.NewRequest(http.MethodGet, "/data", nil)
+	r.Header.Set("Authorization", `Bearer "invalid"`)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+	// the realm defaults to the client ID
+	expected := `Bearer realm="test-client", error="invalid_token", error_description="unauthorized request: invalid token."`
+	if challenge := w.Header().Get("WWW-Authenticate"); challenge != expected {
+		t.Fatalf("Expected challenge %s, got %s", expected, challenge)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/problem+json" {
+		t.Fatalf("Expected problem content type, got %s", contentType)
+	}
+	var problem struct {
+		Type   string `json:"type"`
+		Title  string `json:"title"`
+		Status int    `json:"status"`
+		Detail string `json:"detail"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("Error decoding problem: %s", err)
+	}
+	if problem.Type != "about:blank" || problem.Title != "Unauthorized" || problem.Status != http.StatusUnauthorized ||
+		problem.Detail != "unauthorized request: invalid token." {
+		t.Fatalf("Unexpected problem: %+v", problem)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	if quoted := quote("token \"abc\" \\ \n ü"); quoted != `"token 'abc' '    "` {
+		t.Fatalf("Unexpected quoted string: %s", quoted)
+	}
+}
+
+func TestHandlerExplainDenials(t *testing.T) {
+	v, err := SetupFromConf(Conf{
+		Provider:    basicTestDriver,
+		ProviderURL: "http://localhost",
+		ClientID:    "test-client",
+		Authz: authz.Conf{
+			Enabled:        true,
+			ExplainDenials: true,
+			Rules: []authz.Rule{
+				{ID: "alice", Paths: []string{"/data"}, Methods: []string{"GET"}, Users: []string{"alice"}},
+				{Paths: []string{"/public"}, Methods: []string{"GET"}, Groups: []string{authz.GroupAnonymous}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error setting up validator: %s", err)
+	}
+	handler := v.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	for _, tc := range []struct {
+		name          string
+		authorization string
+		method        string
+		code          int
+		message       string
+		logged        string
+	}{
+		{"forbidden", "Bearer token-bob", http.MethodGet, http.StatusForbidden,
+			"access forbidden: no rule allows this request",
+			"access forbidden for GET /data (user: 'bob', client: ''): no rule allows this request; " +
+				"rule 0 (alice) with path /data: no matching user, group, role, or client"},
+		{"method", "Bearer token-alice", http.MethodPut, http.StatusForbidden,
+			"access forbidden: no rule allows this request",
+			"rule 0 (alice) with path /data: method not allowed: PUT"},
+		{"anonymous", "", http.MethodGet, http.StatusUnauthorized,
+			"unauthorized request: no rule allows this request",
+			"access forbidden for GET /data (anonymous): no rule allows this request; " +
+				"rule 0 (alice) with path /data: no matching user, group, role, or client"},
+	} {
+		logs.Reset()
+		r := httptest.NewRequest(tc.method, "/data", nil)
+		if tc.authorization != "" {
+			r.Header.Set("Authorization", tc.authorization)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != tc.code {
+			t.Fatalf("%s: expected %d, got %d: %s", tc.name, tc.code, w.Code, w.Body)
+		}
+		var body struct {
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: error decoding body: %s", tc.name, err)
+		}
+		if body.Message != tc.message {
+			t.Fatalf("%s: expected message %q, got %q", tc.name, tc.message, body.Message)
+		}
+		if !strings.Contains(logs.String(), tc.logged) {
+			t.Fatalf("%s: expected log %q, got %q", tc.name, tc.logged, logs.String())
+		}
+		// the details of the rules are only logged
+		if strings.Contains(body.Message, "/data") {
+			t.Fatalf("%s: rules revealed in response: %s", tc.name, body.Message)
+		}
+	}
+}
+
+func TestHandlerAuthzSetup(t *testing.T) {
+	conf := &authz.Conf{
+		Rules: []authz.Rule{
+			{Paths: []string{"/data"}, Methods: []string{"GET"}, Users: []string{"alice"}},
+			{Paths: []string{"/public"}, Methods: []string{"GET"}, Groups: []string{authz.GroupAnonymous}},
+		},
+	}
+	v, err := Setup(basicTestDriver, "http://localhost", "test-client", false, conf)
+	if err != nil {
+		t.Fatalf("Error setting up validator: %s", err)
+	}
+	handler := v.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	// enabling authorization after setup has no effect
+	conf.Enabled = true
+
+	for _, tc := range []struct {
+		name          string
+		authorization string
+		path          string
+		code          int
+	}{
+		{"authenticated", "Bearer token-bob", "/data", http.StatusOK},
+		{"anonymous", "", "/public", http.StatusUnauthorized},
+	} {
+		r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.authorization != "" {
+			r.Header.Set("Authorization", tc.authorization)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != tc.code {
+			t.Fatalf("%s: expected %d, got %d: %s", tc.name, tc.code, w.Code, w.Body)
+		}
+	}
+}
+
+const localTestDriver = "local-test"
+
+// localDriver verifies Basic credentials itself, like a local user store, for users with the password "secret"
+type localDriver struct {
+	*basicDriver
+}
+
+func (d localDriver) ValidateBasic(ctx context.Context, username, password string) (bool, *authz.Claims, error) {
+	if password != "secret" {
+		return false, &authz.Claims{Status: "invalid credentials."}, nil
+	}
+	return true, &authz.Claims{Username: username}, nil
+}
+
+func TestHandlerContext(t *testing.T) {
+	Register(localTestDriver, localDriver{testBasicDriver})
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:secret"))
+
+	for _, tc := range []struct {
+		name          string
+		provider      string
+		authorization string
+		username      string
+		token         string
+	}{
+		{"bearer", basicTestDriver, "Bearer token-alice", "alice", "token-alice"},
+		{"basic", basicTestDriver, basic, "alice", "token-alice"},
+		// the driver verified the credentials, no token was obtained
+		{"basic driver", localTestDriver, basic, "alice", ""},
+		{"anonymous", basicTestDriver, "", "", ""},
+	} {
+		v, err := SetupFromConf(Conf{
+			Provider:     tc.provider,
+			ProviderURL:  "http://localhost",
+			ClientID:     "test-client",
+			BasicEnabled: true,
+			Authz: authz.Conf{
+				Enabled: true,
+				Rules:   []authz.Rule{{Paths: []string{"/data"}, Methods: []string{"GET"}, Users: []string{"alice"}, Groups: []string{authz.GroupAnonymous}}},
+			},
+		})
+		if err != nil {
+			t.Fatalf("%s: error setting up validator: %s", tc.name, err)
+		}
+		var claims *authz.Claims
+		var token string
+		var hasClaims, hasToken, called bool
+		handler := v.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, hasClaims = ClaimsFromContext(r.Context())
+			token, hasToken = TokenFromContext(r.Context())
+			called = true
+		}))
+
+		r := httptest.NewRequest(http.MethodGet, "/data", nil)
+		if tc.authorization != "" {
+			r.Header.Set("Authorization", tc.authorization)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if !called {
+			t.Fatalf("%s: next handler not called: %d %s", tc.name, w.Code, w.Body)
+		}
+		if hasClaims != (tc.username != "") || hasClaims && claims.Username != tc.username {
+			t.Fatalf("%s: expected claims of %q, got %+v (%t)", tc.name, tc.username, claims, hasClaims)
+		}
+		if hasToken != (tc.token != "") || token != tc.token {
+			t.Fatalf("%s: expected token %q, got %q (%t)", tc.name, tc.token, token, hasToken)
+		}
+	}
+}
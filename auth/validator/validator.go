@@ -4,9 +4,13 @@
 package validator
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"sync"
 
+	"github.com/linksmart/go-sec/auth/httpclient"
+	"github.com/linksmart/go-sec/auth/jose"
 	"github.com/linksmart/go-sec/authz"
 )
 
@@ -18,56 +22,240 @@ type Driver interface {
 	Validate(serverAddr, clientID string, tokenString string) (bool, *authz.Claims, error)
 }
 
+// ContextDriver is a Driver that validates tokens within a context
+//	The context bounds any requests to the provider, e.g. to fetch keys. Drivers that do not implement it are
+//	wrapped with ContextAdapter.
+type ContextDriver interface {
+	Driver
+	// ValidateContext is like Validate, with the context bounding requests to the provider
+	ValidateContext(ctx context.Context, serverAddr, clientID string, tokenString string) (bool, *authz.Claims, error)
+}
+
+// BasicDriver is a Driver that verifies Basic Authentication credentials itself, e.g. against a local user store
+//	Validators with such a driver do not obtain tokens from the provider for Basic Authentication.
+type BasicDriver interface {
+	Driver
+	// ValidateBasic must verify the credentials of a user
+	//	When they are valid, it must return true together with the claims of the user
+	//	When they are invalid, it must return false and provide the reason in the Claims.Status
+	ValidateBasic(ctx context.Context, username, password string) (bool, *authz.Claims, error)
+}
+
+// ContextAdapter adapts a Driver to ContextDriver
+//	The driver cannot be interrupted, but its result is no longer awaited when the context is done.
+func ContextAdapter(driver Driver) ContextDriver {
+	if d, ok := driver.(ContextDriver); ok {
+		return d
+	}
+	return contextAdapter{driver}
+}
+
+type contextAdapter struct {
+	Driver
+}
+
+func (a contextAdapter) ValidateContext(ctx context.Context, serverAddr, clientID string, tokenString string) (bool, *authz.Claims, error) {
+	if ctx.Done() == nil {
+		return a.Validate(serverAddr, clientID, tokenString)
+	}
+
+	type result struct {
+		valid  bool
+		claims *authz.Claims
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		valid, claims, err := a.Validate(serverAddr, clientID, tokenString)
+		done <- result{valid, claims, err}
+	}()
+	select {
+	case r := <-done:
+		return r.valid, r.claims, r.err
+	case <-ctx.Done():
+		return false, nil, ctx.Err()
+	}
+}
+
+// Factory creates the driver of a Validator, given its configuration
+//	It is called on Setup, so that every Validator has its own driver state (e.g. cached keys of its server).
+//	The returned driver must be safe for concurrent use. The HTTPClient of the configuration is always set and
+//	should be used for all requests to the provider.
+type Factory func(conf Conf) (Driver, error)
+
 var (
 	driversMu sync.Mutex
-	drivers   = make(map[string]Driver)
+	drivers   = make(map[string]Factory)
 )
 
 // Register registers a driver (called by a the driver package)
+//	The driver instance is shared by all Validators. Drivers keeping state should use RegisterFactory instead.
 func Register(name string, driver Driver) {
-	driversMu.Lock()
-	defer driversMu.Unlock()
 	if driver == nil {
 		panic("auth validator driver is nil")
 	}
-	drivers[name] = driver
+	RegisterFactory(name, func(Conf) (Driver, error) {
+		return driver, nil
+	})
+}
+
+// RegisterFactory registers a driver factory (called by a the driver package)
+func RegisterFactory(name string, factory Factory) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if factory == nil {
+		panic("auth validator driver factory is nil")
+	}
+	drivers[name] = factory
 }
 
 // Setup configures and returns the Validator
 // 	parameter authz is optional and can be set to nil
+//	The authorization rules are compiled when enabled; changes to authz after Setup have no effect.
 func Setup(name, serverAddr, clientID string, basicEnabled bool, authz *authz.Conf) (*Validator, error) {
+	return setup(Conf{
+		Provider:     name,
+		ProviderURL:  serverAddr,
+		ClientID:     clientID,
+		BasicEnabled: basicEnabled,
+	}, authz)
+}
+
+// SetupFromConf configures and returns the Validator given the configuration
+func SetupFromConf(conf Conf) (*Validator, error) {
+	authz := conf.Authz
+	return setup(conf, &authz)
+}
+
+func setup(conf Conf, authz *authz.Conf) (*Validator, error) {
 	driversMu.Lock()
-	driveri, ok := drivers[name]
+	factory, ok := drivers[conf.Provider]
 	driversMu.Unlock()
 	if !ok {
-		return nil, fmt.Errorf("unknown validator: '%s' (forgot to import driver?)", name)
+		return nil, fmt.Errorf("unknown validator: '%s' (forgot to import driver?)", conf.Provider)
+	}
+	if err := jose.ValidateAlgorithms(conf.Algorithms); err != nil {
+		return nil, err
+	}
+	httpClient, err := httpclient.Resolve(conf.HTTPClient, conf.Transport)
+	if err != nil {
+		return nil, fmt.Errorf("error creating HTTP client: %s", err)
+	}
+	conf.HTTPClient = httpClient
+	driveri, err := factory(conf)
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s validator: %s", conf.Provider, err)
+	}
+
+	switch conf.ErrorFormat {
+	case "", ErrorFormatJSON, ErrorFormatProblem:
+	default:
+		return nil, fmt.Errorf("unsupported error format: %s", conf.ErrorFormat)
 	}
 
-	return &Validator{
-		driver:       driveri,
-		driverName:   name,
-		serverAddr:   serverAddr,
-		clientID:     clientID,
-		basicEnabled: basicEnabled,
-		authz:        authz,
-	}, nil
+	v := &Validator{
+		driver:       ContextAdapter(driveri),
+		driverName:   conf.Provider,
+		serverAddr:   conf.ProviderURL,
+		clientID:     conf.ClientID,
+		basicEnabled: conf.BasicEnabled,
+		realm:        conf.Realm,
+		errorFormat:  conf.ErrorFormat,
+		httpClient:   httpClient,
+	}
+	if v.realm == "" {
+		v.realm = conf.ClientID
+	}
+	if conf.BasicEnabled {
+		var basicConf BasicAuthConf
+		if conf.BasicAuth != nil {
+			if err := conf.BasicAuth.Validate(); err != nil {
+				return nil, fmt.Errorf("error in basic auth configuration: %s", err)
+			}
+			basicConf = *conf.BasicAuth
+		}
+		v.basicCache, err = newBasicCache(basicConf)
+		if err != nil {
+			return nil, err
+		}
+		v.basicDriver, _ = driveri.(BasicDriver)
+	}
+	if conf.Cache != nil {
+		if err := conf.Cache.Validate(); err != nil {
+			return nil, fmt.Errorf("error in cache configuration: %s", err)
+		}
+		v.cache = NewResultCache(*conf.Cache)
+	}
+	if conf.ClaimsMapping != nil {
+		if err := conf.ClaimsMapping.Validate(); err != nil {
+			return nil, fmt.Errorf("error in claims mapping: %s", err)
+		}
+		mapping := conf.ClaimsMapping.resolve(conf.ClientID)
+		v.claimsMapping = &mapping
+	}
+	if authz != nil && authz.Enabled {
+		// the configuration is compiled on setup, later changes to it have no effect
+		policy, err := authz.Compile()
+		if err != nil {
+			return nil, fmt.Errorf("error compiling authorization rules: %s", err)
+		}
+		v.policy = policy
+		v.explainDenials = authz.ExplainDenials
+	}
+	return v, nil
 }
 
 // Validator struct
 type Validator struct {
-	driver       Driver
+	driver       ContextDriver
 	driverName   string
 	serverAddr   string
 	clientID     string
 	basicEnabled bool
-	// Authorization is optional
-	authz *authz.Conf
+	// realm and errorFormat are used in error responses of Handler
+	realm       string
+	errorFormat string
+	// httpClient is also used to obtain tokens for basic auth
+	httpClient *http.Client
+	// basicCache is set when basic auth is enabled
+	basicCache *basicCache
+	// basicDriver is set when the driver verifies basic auth credentials itself
+	basicDriver BasicDriver
+	// cache is optional
+	cache *ResultCache
+	// claimsMapping is optional
+	claimsMapping *ClaimsMapping
+	// policy is set when authorization is enabled
+	policy         *authz.Policy
+	explainDenials bool
 }
 
 // Validate validates a token
 //	When token is valid, it returns true together with the Profile
 //	When token is invalid, it returns false and provide the reason in the Profile.Status
+//	When caching is enabled, the result is served from the cache if the token was validated recently.
 func (v *Validator) Validate(tokenString string) (bool, *authz.Claims, error) {
-	return v.driver.Validate(v.serverAddr, v.clientID, tokenString)
+	return v.ValidateContext(context.Background(), tokenString)
+}
+
+// ValidateContext is like Validate, with the context bounding requests to the provider
+func (v *Validator) ValidateContext(ctx context.Context, tokenString string) (bool, *authz.Claims, error) {
+	if v.cache != nil {
+		if valid, claims, found := v.cache.Get(tokenString); found {
+			return valid, claims, nil
+		}
+	}
+
+	valid, claims, err := v.driver.ValidateContext(ctx, v.serverAddr, v.clientID, tokenString)
+	if err != nil {
+		return false, nil, err
+	}
+	if valid && v.claimsMapping != nil {
+		v.claimsMapping.apply(claims)
+	}
+	if v.cache != nil {
+		v.cache.Add(tokenString, valid, claims)
+	}
+	return valid, claims, nil
 }
 
@@ -1,9 +1,51 @@
 // Copyright 2014-2016 Fraunhofer Institute for Applied Information Technology FIT
 
 // Package authz provides simple rule-based authorization that can be used to implement access control
+//
+// A rule path matches the requested path and all paths beneath it, e.g. /res matches /res and /res/123.
+// Besides literal segments, rule paths may contain patterns that each span a whole segment:
+//
+//	/things/*/properties    a star matches exactly one non-empty segment
+//	/registry/{id}/history  a named segment is the same as a star, with a name for readability
+//	/registry/**/history    a double star matches zero or more segments
+//
+// Patterns follow the same prefix semantics as literal paths: /things/*/properties also matches
+// /things/1/properties/temperature. Consequently, a trailing /** is equivalent to the literal prefix.
+// Rule paths may also be bound to the identity of the requester using claim templates. A template segment matches
+// only if it is equal to the value of the referenced claim, or to any of its values for groups and roles:
+//
+//	/users/{username}/**         the username of the requester
+//	/clients/{clientID}          the client ID of the requester
+//	/groups/{groups}/documents   any of the groups of the requester
+//	/roles/{roles}               any of the roles of the requester
+//
+// Any other claim of the token can be referenced as {claims.name}, e.g. {claims.tenant}, where nested claims are
+// separated by dots, e.g. {claims.organization.id}. The above names are reserved and cannot be used as named segments.
+//
+// Besides users, groups, roles, and clients, rules may set conditions on arbitrary claims:
+//
+//	"claims": {"tenant": ["acme"], "email_verified": ["true"]}
+//
+// Each listed claim must have one of the given values. Booleans and numbers are given in their JSON representation,
+// array claims match if any of their elements match, and the space-separated scope claim matches if any of its scopes
+// match. A rule that sets claims but no users, groups, roles, or clients applies to all requesters with those claims.
+//
+// Literal and pattern matches have equal precedence: a rule matches when any of its paths
+// matches the requested path or one of its parents.
+//
+// A rule either allows (default) or denies the requests it matches. Requests that match no rule are denied.
+// When several rules match, Conf.CombiningAlgorithm decides the outcome:
+//
+//	deny-overrides    any matching deny rule forbids access (default)
+//	permit-overrides  any matching allow rule grants access
+//	first-applicable  the first matching rule decides
+//
+// For example, with deny-overrides, a deny rule for PUT on /res/system carves out an area from a
+// broader allow rule for PUT on /res, regardless of their order.
 package authz
 
 import (
+	"log"
 	"strings"
 )
 
@@ -11,63 +53,195 @@ import (
 const GroupAnonymous = "anonymous"
 
 // Authorized checks whether a request is authorized given the path, method, and claims
+//	Rules are combined using the configured combining algorithm. Requests are denied if it is unknown.
+//	The rules are evaluated one by one; use Compile to evaluate many requests efficiently.
+func (authz Conf) Authorized(path, method string, claims *Claims) bool {
+	return authz.Rules.authorized(authz.CombiningAlgorithm, path, method, claims)
+}
+
+// Decide is similar to Authorized but returns the decision together with an explanation
+func (authz Conf) Decide(path, method string, claims *Claims) Decision {
+	return authz.Rules.decide(authz.CombiningAlgorithm, path, method, claims)
+}
+
+// Authorized checks whether a request is authorized given the path, method, and claims
+//	Rules are combined using the deny-overrides algorithm.
+//	The rules are evaluated one by one; use Conf.Compile to evaluate many requests efficiently.
 func (rules Rules) Authorized(path, method string, claims *Claims) bool {
+	return rules.authorized(DenyOverrides, path, method, claims)
+}
+
+// Decide is similar to Authorized but returns the decision together with an explanation
+func (rules Rules) Decide(path, method string, claims *Claims) Decision {
+	return rules.decide(DenyOverrides, path, method, claims)
+}
+
+// authorized evaluates the rules one by one, combining them like a compiled Policy
+func (rules Rules) authorized(algorithm, path, method string, claims *Claims) bool {
+	if !knownAlgorithm(algorithm) {
+		return false
+	}
 	if claims == nil {
-		claims = &Claims{Groups: []string{GroupAnonymous}}
-	}
-	// Create a tree of paths
-	// e.g. /path1/path2/path3 -> [/path1/path2/path3 /path1/path2 /path1]
-	// e.g. / -> [/]
-	pathSplit := strings.Split(path, "/")[1:] // split and drop the first part (empty string before slash)
-	pathTree := make([]string, 0, len(pathSplit))
-	// construct tree from longest to shortest (/path1) path
-	for i := len(pathSplit); i >= 1; i-- {
-		pathTree = append(pathTree, "/"+strings.Join(pathSplit[:i], "/"))
-	}
-	//fmt.Printf("%s -> %v -> %v\n", path, pathSplit, pathTree)
-
-	for _, rule := range rules {
-		// take Paths from deprecated Resources
-		if len(rule.Paths) == 0 && len(rule.Resources) != 0 {
-			rule.Paths = rule.Resources
+		claims = &anonymous
+	}
+
+	var allowed bool
+	for i := range rules {
+		rule := &rules[i]
+		// the conditions are cheaper to check than the paths
+		if c := rule.conditions(); !c.applies(path, method, claims) {
+			continue
 		}
-		// take exclusion substrings from deprecated DenyPathSubstrtings
-		if len(rule.ExcludePathSubstrtings) == 0 && len(rule.DenyPathSubstrtings) != 0 {
-			rule.ExcludePathSubstrtings = rule.DenyPathSubstrtings
+		if _, _, matched := rule.matchPath(path, claims); !matched {
+			continue
 		}
-
-		var excludedPath bool
-		for _, substr := range rule.ExcludePathSubstrtings {
-			if strings.Contains(path, substr) {
-				excludedPath = true
-				break
+		deny := rule.Effect == EffectDeny
+		switch algorithm {
+		case PermitOverrides:
+			if !deny {
+				return true
+			}
+		case FirstApplicable:
+			return !deny
+		default: // DenyOverrides
+			if deny {
+				return false
 			}
+			allowed = true
 		}
+	}
+	return allowed
+}
 
-		for _, p := range pathTree {
-			// Return true if a rule matches
-			if inSlice(p, rule.Paths) &&
-				inSlice(method, rule.Methods) &&
-				(inSlice(claims.Username, rule.Users) ||
-					hasIntersection(claims.Groups, rule.Groups) ||
-					hasIntersection(claims.Roles, rule.Roles) ||
-					inSlice(claims.ClientID, rule.Clients)) &&
-				!excludedPath {
-				return true
-			}
+// decide is similar to authorized but returns the decision together with an explanation
+func (rules Rules) decide(algorithm, path, method string, claims *Claims) Decision {
+	if !knownAlgorithm(algorithm) {
+		return Decision{Rule: -1}
+	}
+	if claims == nil {
+		claims = &anonymous
+	}
+
+	d := decider{algorithm: algorithm, decision: Decision{Rule: -1}}
+	for i := range rules {
+		rule := &rules[i]
+		rulePath, node, matched := rule.matchPath(path, claims)
+		if !matched {
+			continue
 		}
+		c := rule.conditions()
+		if d.add(i, rule.ID, rule.Effect == EffectDeny, candidate{path: rulePath, node: node}, c.mismatch(path, method, claims)) {
+			break
+		}
+	}
+	return d.result()
+}
+
+// knownAlgorithm checks whether the combining algorithm is known, logging it otherwise
+func knownAlgorithm(algorithm string) bool {
+	switch algorithm {
+	case "", DenyOverrides, PermitOverrides, FirstApplicable:
+		return true
 	}
+	log.Printf("go-sec/authz: unknown combining algorithm: %s, denying access", algorithm)
 	return false
 }
 
-// inSlice check whether a is in slice
-func inSlice(a string, slice []string) bool {
-	for _, b := range slice {
-		if b == a {
-			return true
+// paths returns the paths of the rule, taking them from the deprecated Resources if not set
+func (rule *Rule) paths() []string {
+	if len(rule.Paths) == 0 {
+		return rule.Resources
+	}
+	return rule.Paths
+}
+
+// matchPath returns the rule path with the longest matched node of the requested path tree
+//	Among paths matching the same node, the first one listed in the rule is returned, as in compiled policies.
+func (rule *Rule) matchPath(path string, claims *Claims) (rulePath, node string, matched bool) {
+	for _, p := range rule.paths() {
+		if n, ok := matchPath(p, path, claims); ok && (!matched || len(n) > len(node)) {
+			rulePath, node, matched = p, n, true
 		}
 	}
-	return false
+	return rulePath, node, matched
+}
+
+// conditions returns the conditions of the rule besides its paths
+func (rule *Rule) conditions() conditions {
+	c := conditions{
+		excludes: rule.ExcludePathSubstrtings,
+		methods:  rule.Methods,
+		users:    rule.Users,
+		groups:   rule.Groups,
+		roles:    rule.Roles,
+		clients:  rule.Clients,
+		claims:   rule.Claims,
+	}
+	// take exclusion substrings from deprecated DenyPathSubstrtings
+	if len(c.excludes) == 0 {
+		c.excludes = rule.DenyPathSubstrtings
+	}
+	return c
+}
+
+// conditions are the conditions of a rule besides its paths
+//	They are checked the same way when evaluating rules one by one and in compiled policies.
+type conditions struct {
+	excludes []string
+	methods  []string
+	users    []string
+	groups   []string
+	roles    []string
+	clients  []string
+	// claims are the accepted values of each claim
+	claims map[string][]string
+}
+
+// applies checks whether the conditions apply to the request
+//	The path is expected to be matched already.
+func (c *conditions) applies(path, method string, claims *Claims) bool {
+	reason, _ := c.check(path, method, claims)
+	return reason == ""
+}
+
+// mismatch is similar to applies but returns the reason why the conditions do not apply, or an empty string if they do
+func (c *conditions) mismatch(path, method string, claims *Claims) string {
+	reason, detail := c.check(path, method, claims)
+	return reason + detail
+}
+
+// check returns the reason why the conditions do not apply together with its detail, e.g. the method
+//	The reason is empty if they apply. Reason and detail are returned separately so that checks do not allocate.
+func (c *conditions) check(path, method string, claims *Claims) (reason, detail string) {
+	for _, substr := range c.excludes {
+		if strings.Contains(path, substr) {
+			return "path contains excluded substring: ", substr
+		}
+	}
+	if !inSlice(method, c.methods) {
+		return "method not allowed: ", method
+	}
+	if !c.hasPrincipal(claims) {
+		return "no matching user, group, role, or client", ""
+	}
+	for claim, values := range c.claims {
+		if !claims.anyValue(claim, func(v string) bool { return inSlice(v, values) }) {
+			return "claim not matched: ", claim
+		}
+	}
+	return "", ""
+}
+
+// hasPrincipal checks whether any of the users, groups, roles, or clients is in the claims
+//	Rules without any of them, but with claim conditions, apply to all principals.
+func (c *conditions) hasPrincipal(claims *Claims) bool {
+	if len(c.users)+len(c.groups)+len(c.roles)+len(c.clients) == 0 && len(c.claims) != 0 {
+		return true
+	}
+	return inSlice(claims.Username, c.users) ||
+		hasIntersection(claims.Groups, c.groups) ||
+		hasIntersection(claims.Roles, c.roles) ||
+		inSlice(claims.ClientID, c.clients)
 }
 
 // hasIntersection checks whether there is a match between two slices
@@ -3,6 +3,7 @@ package authz
 import (
 	"encoding/json"
 	"fmt"
+	"reflect"
 	"testing"
 )
 
@@ -15,6 +16,7 @@ type testCase struct {
 	groups   []string
 	roles    []string
 	clientID string
+	extra    map[string]interface{}
 }
 
 func (t testCase) Stringify() string {
@@ -30,6 +32,7 @@ func (t testCase) Claims() *Claims {
 		Groups:   t.groups,
 		Roles:    t.roles,
 		ClientID: t.clientID,
+		Extra:    t.extra,
 		Status:   "n/a",
 	}
 }
@@ -120,7 +123,7 @@ func TestAuthorized(t *testing.T) {
 		{path: "/res", method: "PUT", clientID: "admin-tool"},
 		{path: "/res", method: "DELETE", groups: []string{"admin"}, user: "john"},
 		{path: "/res", method: "GET", groups: []string{"admin"}, user: "john"},
-		{path: "/res", method: "DELETE", groups: []string{"editor"},roles: []string{"admin"}},
+		{path: "/res", method: "DELETE", groups: []string{"editor"}, roles: []string{"admin"}},
 		{path: "/res/CaseSensitiveSecret", method: "GET", user: "john"},
 	}
 
@@ -134,6 +137,415 @@ func TestAuthorized(t *testing.T) {
 	runAllowDenyTests(confRules, allowCases, denyCases, t)
 }
 
+func TestAuthorizedPathPatterns(t *testing.T) {
+	confRules := `[
+		{
+			"paths": ["/things/*/properties"],
+			"methods": ["GET"],
+			"groups": ["viewer"]
+		},
+		{
+			"paths": ["/registry/{id}/history"],
+			"methods": ["GET"],
+			"groups": ["viewer"]
+		},
+		{
+			"paths": ["/archive/**/raw"],
+			"methods": ["GET"],
+			"groups": ["viewer"]
+		},
+		{
+			"paths": ["/files/**"],
+			"methods": ["GET"],
+			"groups": ["viewer"]
+		}
+	]`
+
+	allowCases := []testCase{
+		{path: "/things/1/properties", method: "GET", groups: []string{"viewer"}},
+		{path: "/things/1/properties/temperature", method: "GET", groups: []string{"viewer"}},
+		{path: "/registry/abc/history", method: "GET", groups: []string{"viewer"}},
+		{path: "/registry/abc/history/2", method: "GET", groups: []string{"viewer"}},
+		{path: "/archive/raw", method: "GET", groups: []string{"viewer"}},
+		{path: "/archive/2020/01/raw", method: "GET", groups: []string{"viewer"}},
+		{path: "/archive/2020/raw/file", method: "GET", groups: []string{"viewer"}},
+		{path: "/files", method: "GET", groups: []string{"viewer"}},
+		{path: "/files/a/b", method: "GET", groups: []string{"viewer"}},
+	}
+
+	denyCases := []testCase{
+		{path: "/things", method: "GET", groups: []string{"viewer"}},
+		{path: "/things/1", method: "GET", groups: []string{"viewer"}},
+		{path: "/things//properties", method: "GET", groups: []string{"viewer"}},
+		{path: "/things/1/2/properties", method: "GET", groups: []string{"viewer"}},
+		{path: "/things/1/events", method: "GET", groups: []string{"viewer"}},
+		{path: "/registry/abc", method: "GET", groups: []string{"viewer"}},
+		{path: "/registry/abc/history", method: "PUT", groups: []string{"viewer"}},
+		{path: "/archive/2020", method: "GET", groups: []string{"viewer"}},
+		{path: "/filesystem", method: "GET", groups: []string{"viewer"}},
+		{path: "/things/1/properties", method: "GET", groups: []string{"editor"}},
+	}
+
+	runAllowDenyTests(confRules, allowCases, denyCases, t)
+	runConfAllowDenyTests("", confRules, allowCases, denyCases, t)
+}
+
+func TestAuthorizedClaimTemplates(t *testing.T) {
+	confRules := `[
+		{
+			"paths": ["/users/{username}/**"],
+			"methods": ["GET", "PUT"],
+			"groups": ["user"]
+		},
+		{
+			"paths": ["/clients/{claims.clientID}"],
+			"methods": ["GET"],
+			"clients": ["tool", "other-tool"]
+		},
+		{
+			"paths": ["/groups/{groups}/documents", "/roles/{claims.roles}"],
+			"methods": ["GET"],
+			"groups": ["user"]
+		}
+	]`
+
+	allowCases := []testCase{
+		{path: "/users/john", method: "GET", user: "john", groups: []string{"user"}},
+		{path: "/users/john/settings", method: "PUT", user: "john", groups: []string{"user"}},
+		{path: "/clients/tool", method: "GET", clientID: "tool"},
+		{path: "/groups/user/documents", method: "GET", groups: []string{"user"}},
+		{path: "/groups/team/documents/1", method: "GET", groups: []string{"user", "team"}},
+		{path: "/roles/admin", method: "GET", groups: []string{"user"}, roles: []string{"viewer", "admin"}},
+	}
+
+	denyCases := []testCase{
+		{path: "/users/jane", method: "GET", user: "john", groups: []string{"user"}},
+		{path: "/users/jane/settings", method: "GET", user: "john", groups: []string{"user"}},
+		{path: "/users/", method: "GET", groups: []string{"user"}},
+		{path: "/users/john", method: "GET", user: "john", groups: []string{"guest"}},
+		{path: "/clients/other-tool", method: "GET", clientID: "tool"},
+		{path: "/groups/team/documents", method: "GET", groups: []string{"user"}},
+		{path: "/roles/admin", method: "GET", groups: []string{"user"}, roles: []string{"viewer"}},
+		{path: "/users/anonymous", method: "GET", anonymous: true},
+	}
+
+	runAllowDenyTests(confRules, allowCases, denyCases, t)
+	runConfAllowDenyTests("", confRules, allowCases, denyCases, t)
+}
+
+func TestAuthorizedClaimConditions(t *testing.T) {
+	confRules := `[
+		{
+			"paths": ["/tenants/{claims.tenant}"],
+			"methods": ["GET"],
+			"claims": {"email_verified": ["true"]}
+		},
+		{
+			"paths": ["/devices"],
+			"methods": ["GET"],
+			"groups": ["operator"],
+			"claims": {"organization.site": ["berlin", "bonn"], "scope": ["devices"]}
+		},
+		{
+			"paths": ["/levels"],
+			"methods": ["GET"],
+			"claims": {"level": ["2"], "https://example.com/departments": ["rnd"]}
+		}
+	]`
+
+	acme := map[string]interface{}{"tenant": "acme", "email_verified": true}
+	operator := map[string]interface{}{
+		"organization": map[string]interface{}{"site": "bonn"},
+		"scope":        "openid devices profile",
+	}
+	leveled := map[string]interface{}{"level": float64(2), "https://example.com/departments": []interface{}{"sales", "rnd"}}
+
+	allowCases := []testCase{
+		{path: "/tenants/acme", method: "GET", extra: acme},
+		{path: "/tenants/acme/users", method: "GET", user: "john", extra: acme},
+		{path: "/devices", method: "GET", groups: []string{"operator"}, extra: operator},
+		{path: "/levels", method: "GET", extra: leveled},
+	}
+
+	denyCases := []testCase{
+		{path: "/tenants/other", method: "GET", extra: acme},
+		{path: "/tenants/acme", method: "GET", extra: map[string]interface{}{"tenant": "acme", "email_verified": false}},
+		{path: "/tenants/acme", method: "GET"},
+		{path: "/devices", method: "GET", groups: []string{"developer"}, extra: operator},
+		{path: "/devices", method: "GET", groups: []string{"operator"}, extra: map[string]interface{}{"scope": "devices"}},
+		{path: "/devices", method: "GET", groups: []string{"operator"}, extra: map[string]interface{}{
+			"organization": map[string]interface{}{"site": "bonn"}, "scope": "openid"}},
+		{path: "/levels", method: "GET", extra: map[string]interface{}{"level": float64(3), "https://example.com/departments": "rnd"}},
+		{path: "/levels", method: "GET", anonymous: true},
+	}
+
+	runAllowDenyTests(confRules, allowCases, denyCases, t)
+	runConfAllowDenyTests("", confRules, allowCases, denyCases, t)
+}
+
+func TestAuthorizedDenyRules(t *testing.T) {
+	confRules := `[
+		{
+			"paths": ["/res"],
+			"methods": ["GET", "PUT"],
+			"groups": ["editor"]
+		},
+		{
+			"paths": ["/res/system"],
+			"methods": ["PUT"],
+			"groups": ["editor"],
+			"effect": "deny"
+		},
+		{
+			"paths": ["/res/system/public"],
+			"methods": ["PUT"],
+			"groups": ["editor"]
+		}
+	]`
+
+	t.Run(DenyOverrides, func(t *testing.T) {
+		allowCases := []testCase{
+			{path: "/res", method: "PUT", groups: []string{"editor"}},
+			{path: "/res/system", method: "GET", groups: []string{"editor"}},
+		}
+		denyCases := []testCase{
+			{path: "/res/system", method: "PUT", groups: []string{"editor"}},
+			{path: "/res/system/123", method: "PUT", groups: []string{"editor"}},
+			{path: "/res/system/public", method: "PUT", groups: []string{"editor"}},
+		}
+		runConfAllowDenyTests(DenyOverrides, confRules, allowCases, denyCases, t)
+	})
+
+	t.Run(PermitOverrides, func(t *testing.T) {
+		allowCases := []testCase{
+			{path: "/res", method: "PUT", groups: []string{"editor"}},
+			{path: "/res/system", method: "PUT", groups: []string{"editor"}},
+			{path: "/res/system/public", method: "PUT", groups: []string{"editor"}},
+		}
+		denyCases := []testCase{
+			{path: "/res", method: "DELETE", groups: []string{"editor"}},
+		}
+		runConfAllowDenyTests(PermitOverrides, confRules, allowCases, denyCases, t)
+	})
+
+	t.Run(FirstApplicable, func(t *testing.T) {
+		reversed := `[
+			{
+				"paths": ["/res/system/public"],
+				"methods": ["PUT"],
+				"groups": ["editor"]
+			},
+			{
+				"paths": ["/res/system"],
+				"methods": ["PUT"],
+				"groups": ["editor"],
+				"effect": "deny"
+			},
+			{
+				"paths": ["/res"],
+				"methods": ["GET", "PUT"],
+				"groups": ["editor"]
+			}
+		]`
+		allowCases := []testCase{
+			{path: "/res", method: "PUT", groups: []string{"editor"}},
+			{path: "/res/system/public", method: "PUT", groups: []string{"editor"}},
+		}
+		denyCases := []testCase{
+			{path: "/res/system", method: "PUT", groups: []string{"editor"}},
+			{path: "/res/system/123", method: "PUT", groups: []string{"editor"}},
+		}
+		runConfAllowDenyTests(FirstApplicable, reversed, allowCases, denyCases, t)
+	})
+}
+
+func TestDecide(t *testing.T) {
+	rules := Rules{
+		{ID: "readers", Paths: []string{"/res"}, Methods: []string{"GET"}, Groups: []string{"reader"}},
+		{Paths: []string{"/res/{id}"}, Methods: []string{"GET", "PUT"}, Groups: []string{"editor"}, ExcludePathSubstrtings: []string{"secret"}},
+		{ID: "no-system", Paths: []string{"/res/system"}, Methods: []string{"PUT"}, Groups: []string{"editor"}, Effect: EffectDeny},
+		{Paths: []string{"/other"}, Methods: []string{"GET"}, Groups: []string{"editor"}},
+	}
+
+	t.Run("allowed", func(t *testing.T) {
+		d := rules.Decide("/res/123/abc", "PUT", &Claims{Groups: []string{"editor"}})
+		if !d.Allowed || d.Rule != 1 || d.Path != "/res/{id}" || d.Node != "/res/123" || d.Mismatches != nil {
+			t.Fatalf("Unexpected decision: %+v", d)
+		}
+	})
+
+	t.Run("denied by rule", func(t *testing.T) {
+		d := rules.Decide("/res/system", "PUT", &Claims{Groups: []string{"editor"}})
+		if d.Allowed || d.Rule != 2 || d.RuleID != "no-system" || d.Node != "/res/system" {
+			t.Fatalf("Unexpected decision: %+v", d)
+		}
+		if len(d.Mismatches) != 1 || d.Mismatches[0].Rule != 0 {
+			t.Fatalf("Unexpected mismatches: %+v", d.Mismatches)
+		}
+		if d.Summary() != "denied by rule 2 (no-system)" {
+			t.Fatalf("Unexpected summary: %s", d.Summary())
+		}
+	})
+
+	t.Run("no matching rule", func(t *testing.T) {
+		d := rules.Decide("/res/secret", "DELETE", &Claims{Groups: []string{"editor"}})
+		if d.Allowed || d.Rule != -1 {
+			t.Fatalf("Unexpected decision: %+v", d)
+		}
+		expected := []Mismatch{
+			{Rule: 0, RuleID: "readers", Path: "/res", Reason: "method not allowed: DELETE"},
+			{Rule: 1, Path: "/res/{id}", Reason: "path contains excluded substring: secret"},
+		}
+		if len(d.Mismatches) != len(expected) {
+			t.Fatalf("Unexpected mismatches: %+v", d.Mismatches)
+		}
+		for i := range expected {
+			if d.Mismatches[i] != expected[i] {
+				t.Fatalf("Unexpected mismatch: %+v, expected %+v", d.Mismatches[i], expected[i])
+			}
+		}
+	})
+}
+
+func TestDecideCompiled(t *testing.T) {
+	rules := Rules{
+		{ID: "readers", Paths: []string{"/res", "/docs/**"}, Methods: []string{"GET"}, Groups: []string{"reader"}},
+		{Paths: []string{"/res/{id}", "/res/*/items"}, Methods: []string{"GET", "PUT"}, Groups: []string{"editor"}, ExcludePathSubstrtings: []string{"secret"}},
+		{ID: "no-system", Paths: []string{"/res/system"}, Methods: []string{"PUT"}, Groups: []string{"editor"}, Effect: EffectDeny},
+		{Paths: []string{"/users/{username}/**"}, Methods: []string{"GET"}, Claims: map[string][]string{"tenant": {"acme"}}},
+	}
+	claims := []*Claims{
+		nil,
+		{Groups: []string{"reader"}},
+		{Groups: []string{"editor", "reader"}},
+		{Username: "john", Extra: map[string]interface{}{"tenant": "acme"}},
+	}
+	requests := []struct{ path, method string }{
+		{"/res", "GET"}, {"/res/123/items/4", "PUT"}, {"/res/system", "PUT"}, {"/res/secret", "GET"},
+		{"/docs", "GET"}, {"/docs/a/b", "GET"}, {"/users/john/files", "GET"}, {"/users/jane", "GET"}, {"/", "GET"},
+	}
+	for _, algorithm := range []string{DenyOverrides, PermitOverrides, FirstApplicable} {
+		conf := Conf{Rules: rules, CombiningAlgorithm: algorithm}
+		policy, err := conf.Compile()
+		if err != nil {
+			t.Fatalf("Error compiling policy: %s", err)
+		}
+		for _, c := range claims {
+			for _, r := range requests {
+				d, compiled := conf.Decide(r.path, r.method, c), policy.Decide(r.path, r.method, c)
+				if !reflect.DeepEqual(d, compiled) {
+					t.Errorf("%s %s %s %+v: decision %+v differs from compiled %+v", algorithm, r.method, r.path, c, d, compiled)
+				}
+				if conf.Authorized(r.path, r.method, c) != compiled.Allowed || policy.Authorized(r.path, r.method, c) != compiled.Allowed {
+					t.Errorf("%s %s %s %+v: Authorized differs from decision %+v", algorithm, r.method, r.path, c, compiled)
+				}
+			}
+		}
+	}
+}
+
+func TestDecideRulePath(t *testing.T) {
+	rules := Rules{
+		{Paths: []string{"/things", "/things/{claims.site}", "/things/*/items", "/things/1", "/things/*"}, Methods: []string{"GET"}, Groups: []string{"reader"},
+			ExcludePathSubstrtings: []string{"secret"}},
+	}
+	claims := &Claims{Groups: []string{"reader"}, Extra: map[string]interface{}{"site": "1"}}
+	policy, err := Conf{Rules: rules}.Compile()
+	if err != nil {
+		t.Fatalf("Error compiling policy: %s", err)
+	}
+	for _, tc := range []struct {
+		path     string
+		rulePath string
+		node     string
+	}{
+		// the path with the longest node is taken
+		{"/things/2/items/3", "/things/*/items", "/things/2/items"},
+		// among paths with the same node, the first listed is taken
+		{"/things/1/x", "/things/{claims.site}", "/things/1"},
+		{"/things/2", "/things/*", "/things/2"},
+		{"/things", "/things", "/things"},
+	} {
+		d, compiled := rules.Decide(tc.path, "GET", claims), policy.Decide(tc.path, "GET", claims)
+		if !reflect.DeepEqual(d, compiled) {
+			t.Errorf("%s: decision %+v differs from compiled %+v", tc.path, d, compiled)
+		}
+		if d.Path != tc.rulePath || d.Node != tc.node {
+			t.Errorf("%s: expected path %s with node %s, got %+v", tc.path, tc.rulePath, tc.node, d)
+		}
+	}
+
+	// the same holds for the paths of mismatches
+	d, compiled := rules.Decide("/things/1/secret", "GET", claims), policy.Decide("/things/1/secret", "GET", claims)
+	if !reflect.DeepEqual(d, compiled) {
+		t.Errorf("Decision %+v differs from compiled %+v", d, compiled)
+	}
+	if len(d.Mismatches) != 1 || d.Mismatches[0].Path != "/things/{claims.site}" {
+		t.Errorf("Unexpected mismatches: %+v", d.Mismatches)
+	}
+}
+
+func TestAuthorizedInvalidConf(t *testing.T) {
+	rules := Rules{
+		{Paths: []string{"/things/x*", "/users/{claims.}"}, Methods: []string{"GET"}, Groups: []string{"admin"}},
+		{Paths: []string{"/res"}, Methods: []string{"GET"}, Groups: []string{"admin"}},
+	}
+	claims := &Claims{Groups: []string{"admin"}}
+
+	// invalid paths never match
+	if rules.Authorized("/things/x*", "GET", claims) || rules.Authorized("/users/{claims.}", "GET", claims) {
+		t.Errorf("Invalid path matched")
+	}
+	if !rules.Authorized("/res", "GET", claims) {
+		t.Errorf("Valid rule did not apply")
+	}
+
+	// unknown algorithms deny access
+	conf := Conf{Rules: rules, CombiningAlgorithm: "only-one-applicable"}
+	if conf.Authorized("/res", "GET", claims) {
+		t.Errorf("Allowed with unknown combining algorithm")
+	}
+	if d := conf.Decide("/res", "GET", claims); d.Allowed || d.Rule != -1 {
+		t.Errorf("Unexpected decision with unknown combining algorithm: %+v", d)
+	}
+}
+
+func TestRulesAuthorizedAllocations(t *testing.T) {
+	rules := benchmarkConf(100).Rules
+	claims := &Claims{Username: "john", Groups: []string{"group99"}}
+	allocs := testing.AllocsPerRun(100, func() {
+		if !rules.Authorized("/res99/123/items/456", "GET", claims) {
+			t.Fatalf("Request not authorized")
+		}
+		rules.Authorized("/res99/123/secret", "GET", claims)
+	})
+	if allocs != 0 {
+		t.Fatalf("Expected no allocations, got %v", allocs)
+	}
+}
+
+func TestConfValidatePathPatterns(t *testing.T) {
+	for path, valid := range map[string]bool{
+		"/res":                true,
+		"/":                   true,
+		"/things/*/props":     true,
+		"/registry/{id}/hist": true,
+		"/a/**/b/**":          true,
+		"res":                 false,
+		"/things/x*":          false,
+		"/registry/{}/hist":   false,
+		"/registry/{id":       false,
+		"/users/{username}":   true,
+		"/users/{claims.x}":   true,
+		"/users/{claims.}":    false,
+	} {
+		conf := Conf{Rules: Rules{{Paths: []string{path}, Methods: []string{"GET"}, Groups: []string{"admin"}}}}
+		if err := conf.Validate(); (err == nil) != valid {
+			t.Errorf("Unexpected validation result for %s: %v", path, err)
+		}
+	}
+}
+
 func runAllowDenyTests(authzRules string, allowCases, denyCases []testCase, t *testing.T) {
 	var rules Rules
 	err := json.Unmarshal([]byte(authzRules), &rules)
@@ -164,3 +576,133 @@ func runAllowDenyTests(authzRules string, allowCases, denyCases []testCase, t *t
 		t.Logf("Given rules: %s", b)
 	}
 }
+
+func runConfAllowDenyTests(algorithm, authzRules string, allowCases, denyCases []testCase, t *testing.T) {
+	conf := Conf{Enabled: true, CombiningAlgorithm: algorithm}
+	err := json.Unmarshal([]byte(authzRules), &conf.Rules)
+	if err != nil {
+		t.Fatalf("Error loading authz config json: %s", err)
+	}
+	if err := conf.Validate(); err != nil {
+		t.Fatalf("Invalid authz config: %s", err)
+	}
+
+	policy, err := conf.Compile()
+	if err != nil {
+		t.Fatalf("Error compiling authz config: %s", err)
+	}
+
+	t.Run("allow", func(t *testing.T) {
+		for _, c := range allowCases {
+			if !conf.Authorized(c.path, c.method, c.Claims()) {
+				t.Logf("Did not allow %+v", c)
+				t.Fail()
+			}
+			if !policy.Authorized(c.path, c.method, c.Claims()) {
+				t.Logf("Compiled policy did not allow %+v", c)
+				t.Fail()
+			}
+		}
+	})
+
+	t.Run("deny", func(t *testing.T) {
+		for _, c := range denyCases {
+			if conf.Authorized(c.path, c.method, c.Claims()) {
+				t.Logf("Did not deny %+v", c)
+				t.Fail()
+			}
+			if policy.Authorized(c.path, c.method, c.Claims()) {
+				t.Logf("Compiled policy did not deny %+v", c)
+				t.Fail()
+			}
+		}
+	})
+
+	if t.Failed() {
+		b, _ := json.MarshalIndent(conf.Rules, "", "\t")
+		t.Logf("Given rules: %s", b)
+	}
+}
+
+func TestCompile(t *testing.T) {
+	_, err := Conf{Rules: Rules{{Paths: []string{"/things/x*"}, Methods: []string{"GET"}, Groups: []string{"admin"}}}}.Compile()
+	if err == nil {
+		t.Errorf("Expected error for invalid path")
+	}
+	_, err = Conf{CombiningAlgorithm: "only-one-applicable"}.Compile()
+	if err == nil {
+		t.Errorf("Expected error for unknown combining algorithm")
+	}
+}
+
+func TestPolicyAuthorizedAllocations(t *testing.T) {
+	conf := benchmarkConf(100)
+	conf.Rules = append(conf.Rules, Rule{
+		Paths:   []string{"/tenants/{claims.tenant}"},
+		Methods: []string{"GET"},
+		Claims:  map[string][]string{"roles": {"admin"}, "site": {"bonn"}},
+	})
+	policy, err := conf.Compile()
+	if err != nil {
+		t.Fatalf("Error compiling policy: %s", err)
+	}
+	claims := &Claims{Username: "john", Groups: []string{"group99"}, Roles: []string{"admin"},
+		Extra: map[string]interface{}{"tenant": "acme", "site": []interface{}{"berlin", "bonn"}}}
+	allocs := testing.AllocsPerRun(100, func() {
+		if !policy.Authorized("/res99/123/items/456", "GET", claims) {
+			t.Fatalf("Request not authorized")
+		}
+		policy.Authorized("/res99/123/secret", "GET", claims)
+		policy.Authorized("/res1/123/items", "GET", nil)
+		if !policy.Authorized("/tenants/acme", "GET", claims) {
+			t.Fatalf("Request not authorized")
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("Expected no allocations, got %v", allocs)
+	}
+}
+
+func BenchmarkPolicyAuthorized(b *testing.B) {
+	policy, err := benchmarkConf(300).Compile()
+	if err != nil {
+		b.Fatalf("Error compiling policy: %s", err)
+	}
+	claims := &Claims{Username: "john", Groups: []string{"group1", "group299"}, Roles: []string{"role1"}}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		policy.Authorized("/res299/123/items/456", "GET", claims)
+	}
+}
+
+func BenchmarkRulesAuthorized(b *testing.B) {
+	rules := benchmarkConf(300).Rules
+	claims := &Claims{Username: "john", Groups: []string{"group1", "group299"}, Roles: []string{"role1"}}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rules.Authorized("/res299/123/items/456", "GET", claims)
+	}
+}
+
+// benchmarkConf returns a config with n allow rules and n deny rules
+func benchmarkConf(n int) Conf {
+	conf := Conf{Enabled: true}
+	for i := 0; i < n; i++ {
+		conf.Rules = append(conf.Rules,
+			Rule{
+				Paths:   []string{fmt.Sprintf("/res%d/*/items", i), fmt.Sprintf("/res%d/**/history", i)},
+				Methods: []string{"GET", "PUT"},
+				Groups:  []string{fmt.Sprintf("group%d", i), GroupAnonymous},
+				Roles:   []string{fmt.Sprintf("role%d", i)},
+			},
+			Rule{
+				Paths:   []string{fmt.Sprintf("/res%d/{id}/secret", i)},
+				Methods: []string{"GET", "PUT"},
+				Groups:  []string{fmt.Sprintf("group%d", i)},
+				Effect:  EffectDeny,
+			})
+	}
+	return conf
+}
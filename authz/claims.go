@@ -1,11 +1,136 @@
 package authz
 
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
 // Claims are the profile attributes of user/client that are part of the JWT claims
 type Claims struct {
 	Username string
 	Groups   []string
 	Roles    []string
 	ClientID string // for tokens issued as part of client credentials grant
+	// Extra is the full set of verified claims of the token (e.g. tenant, email_verified, scope)
+	Extra map[string]interface{}
 	// Status is the message given when token is not validated
 	Status string
 }
+
+// Names of claims that can be referenced in rule paths
+const (
+	ClaimUsername = "username"
+	ClaimGroups   = "groups"
+	ClaimRoles    = "roles"
+	ClaimClientID = "clientID"
+)
+
+// isClaimName checks whether the name refers to one of the claims with a field in Claims
+func isClaimName(name string) bool {
+	switch name {
+	case ClaimUsername, ClaimGroups, ClaimRoles, ClaimClientID:
+		return true
+	}
+	return false
+}
+
+// hasValue checks whether the named claim has the given non-empty value
+//	Multi-valued claims have the value if any of their values is equal.
+func (c *Claims) hasValue(name, value string) bool {
+	if value == "" {
+		return false
+	}
+	return c.anyValue(name, func(v string) bool {
+		return v == value
+	})
+}
+
+// anyValue checks whether any value of the named claim satisfies the given function
+//	The names in ClaimUsername, ClaimGroups, ClaimRoles, and ClaimClientID refer to the fields of Claims.
+//	Other names are looked up in Extra using LookupClaim.
+func (c *Claims) anyValue(name string, fn func(string) bool) bool {
+	switch name {
+	case ClaimUsername:
+		return c.Username != "" && fn(c.Username)
+	case ClaimGroups:
+		return anyString(c.Groups, fn)
+	case ClaimRoles:
+		return anyString(c.Roles, fn)
+	case ClaimClientID:
+		return c.ClientID != "" && fn(c.ClientID)
+	}
+	v, found := LookupClaim(c.Extra, name)
+	if !found {
+		return false
+	}
+	return anyClaimValue(name, v, fn)
+}
+
+// anyClaimValue checks whether the claim value, or any of its elements, satisfies the given function
+//	Booleans and numbers are compared in their JSON representation, e.g. true or 42.
+//	The scope claim is a space-separated list of values (RFC 8693).
+func anyClaimValue(name string, v interface{}, fn func(string) bool) bool {
+	switch v := v.(type) {
+	case string:
+		if name == "scope" {
+			return anyString(strings.Fields(v), fn)
+		}
+		return fn(v)
+	case bool:
+		return fn(strconv.FormatBool(v))
+	case float64:
+		return fn(strconv.FormatFloat(v, 'f', -1, 64))
+	case json.Number:
+		return fn(v.String())
+	case []string:
+		return anyString(v, fn)
+	case []interface{}:
+		for _, e := range v {
+			if anyClaimValue(name, e, fn) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func anyString(values []string, fn func(string) bool) bool {
+	for _, v := range values {
+		if fn(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// LookupClaim returns the value of a claim by name
+//	Nested claims are referenced with dots, e.g. realm_access.roles. A name that exists as it is takes precedence,
+//	so that namespaced claims such as https://example.com/tenant can be looked up too.
+func LookupClaim(claims map[string]interface{}, name string) (interface{}, bool) {
+	if v, found := claims[name]; found {
+		return v, true
+	}
+	// try the part before each dot as a parent claim
+	for i := 0; i < len(name); i++ {
+		if name[i] != '.' {
+			continue
+		}
+		if nested, ok := claims[name[:i]].(map[string]interface{}); ok {
+			if v, found := LookupClaim(nested, name[i+1:]); found {
+				return v, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// inSlice check whether a is in slice
+func inSlice(a string, slice []string) bool {
+	for _, b := range slice {
+		if b == a {
+			return true
+		}
+	}
+	return false
+}
@@ -13,12 +13,41 @@ type Conf struct {
 	Enabled bool `json:"enabled"`
 	// Authorization rules
 	Rules Rules `json:"rules"`
+	// CombiningAlgorithm decides how the effects of multiple matching rules are combined.
+	//	Defaults to deny-overrides.
+	CombiningAlgorithm string `json:"combiningAlgorithm"`
+	// ExplainDenials toggles explanations of denied requests.
+	//	When enabled, a summary of the decision is given in responses and the full decision is logged.
+	//	This includes anonymous requests, which are denied as unauthorized.
+	ExplainDenials bool `json:"explainDenials"`
 }
 
+// Rule effects
+const (
+	// EffectAllow grants access to the matching requests (default)
+	EffectAllow = "allow"
+	// EffectDeny forbids access to the matching requests
+	EffectDeny = "deny"
+)
+
+// Rule-combining algorithms
+const (
+	// DenyOverrides denies access when any deny rule matches, otherwise allows access when any allow rule matches
+	DenyOverrides = "deny-overrides"
+	// PermitOverrides allows access when any allow rule matches.
+	//	Since requests are denied by default, deny rules have no effect under this algorithm.
+	PermitOverrides = "permit-overrides"
+	// FirstApplicable takes the effect of the first matching rule, in the order of rules
+	FirstApplicable = "first-applicable"
+)
+
 type Rules []Rule
 
 // Authorization rule
 type Rule struct {
+	// ID is an optional identifier used to refer to the rule in decisions
+	ID string `json:"id"`
+	// Paths are the protected paths and may include patterns (*, **, {name}). See package docs.
 	Paths                  []string `json:"paths"`
 	Methods                []string `json:"methods"`
 	Users                  []string `json:"users"`
@@ -26,6 +55,11 @@ type Rule struct {
 	Roles                  []string `json:"roles"`
 	Clients                []string `json:"clients"`
 	ExcludePathSubstrtings []string `json:"excludePathSubstrings"`
+	// Claims are conditions on the claims of the requester, e.g. {"tenant": ["acme"]}.
+	//	Each listed claim must have one of the given values. See package docs.
+	Claims map[string][]string `json:"claims"`
+	// Effect is either allow (default) or deny
+	Effect string `json:"effect"`
 	// Deprecated. Use Paths instead.
 	Resources []string `json:"resources"`
 	// Deprecated. Use ExcludePathSubstrtings instead.
@@ -35,6 +69,12 @@ type Rule struct {
 // Validate authorization config
 func (authz Conf) Validate() error {
 
+	switch authz.CombiningAlgorithm {
+	case "", DenyOverrides, PermitOverrides, FirstApplicable:
+	default:
+		return fmt.Errorf("unknown combining algorithm: %s", authz.CombiningAlgorithm)
+	}
+
 	// Check each authorization rule
 	for _, rule := range authz.Rules {
 		// take Paths from deprecated Resources
@@ -46,11 +86,24 @@ func (authz Conf) Validate() error {
 		if len(rule.Paths) == 0 {
 			return errors.New("no paths in an authorization rule")
 		}
+		for _, path := range rule.Paths {
+			if _, err := parsePathPattern(path); err != nil {
+				return err
+			}
+		}
 		if len(rule.Methods) == 0 {
 			return errors.New("no methods in an authorization rule")
 		}
-		if len(rule.Users)+len(rule.Groups)+len(rule.Roles)+len(rule.Clients) == 0 {
-			return errors.New("at least one user, group, role, or client must be set in each authorization rule")
+		if len(rule.Users)+len(rule.Groups)+len(rule.Roles)+len(rule.Clients)+len(rule.Claims) == 0 {
+			return errors.New("at least one user, group, role, client, or claim must be set in each authorization rule")
+		}
+		for claim, values := range rule.Claims {
+			if len(values) == 0 {
+				return fmt.Errorf("no values for claim %s in an authorization rule", claim)
+			}
+		}
+		if rule.Effect != "" && rule.Effect != EffectAllow && rule.Effect != EffectDeny {
+			return fmt.Errorf("invalid effect in an authorization rule: %s", rule.Effect)
 		}
 
 		if len(rule.DenyPathSubstrtings) != 0 {
@@ -0,0 +1,67 @@
+package authz
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Decision is the outcome of an authorization request together with its explanation
+type Decision struct {
+	// Allowed tells whether the request is authorized
+	Allowed bool `json:"allowed"`
+	// Rule is the index of the rule that decided the outcome, or -1 if no rule matched the request
+	Rule int `json:"rule"`
+	// RuleID is the ID of the deciding rule, if set
+	RuleID string `json:"ruleID,omitempty"`
+	// Path is the path of the deciding rule that matched the request
+	Path string `json:"path,omitempty"`
+	// Node is the node of the requested path tree that was matched, e.g. /res when /res/123 is requested
+	Node string `json:"node,omitempty"`
+	// Mismatches explains why the candidate rules (those with a matching path) did not match the request.
+	//	It is only set when the request is denied.
+	Mismatches []Mismatch `json:"mismatches,omitempty"`
+}
+
+// Mismatch explains why a rule did not match a request
+type Mismatch struct {
+	// Rule is the index of the rule
+	Rule int `json:"rule"`
+	// RuleID is the ID of the rule, if set
+	RuleID string `json:"ruleID,omitempty"`
+	// Path is the path of the rule that matched the request
+	Path string `json:"path"`
+	// Reason is why the rule did not match, e.g. the method is not allowed
+	Reason string `json:"reason"`
+}
+
+// Summary returns an explanation of the decision that does not reveal the rules' details
+func (d Decision) Summary() string {
+	switch {
+	case d.Allowed:
+		return fmt.Sprintf("allowed by %s", ruleName(d.Rule, d.RuleID))
+	case d.Rule != -1:
+		return fmt.Sprintf("denied by %s", ruleName(d.Rule, d.RuleID))
+	default:
+		return "no rule allows this request"
+	}
+}
+
+// String returns the full explanation of the decision
+func (d Decision) String() string {
+	var b strings.Builder
+	b.WriteString(d.Summary())
+	if d.Rule != -1 {
+		fmt.Fprintf(&b, " (path %s matched %s)", d.Path, d.Node)
+	}
+	for _, m := range d.Mismatches {
+		fmt.Fprintf(&b, "; %s with path %s: %s", ruleName(m.Rule, m.RuleID), m.Path, m.Reason)
+	}
+	return b.String()
+}
+
+func ruleName(index int, id string) string {
+	if id != "" {
+		return fmt.Sprintf("rule %d (%s)", index, id)
+	}
+	return fmt.Sprintf("rule %d", index)
+}
@@ -0,0 +1,170 @@
+package authz
+
+import (
+	"fmt"
+	"strings"
+)
+
+// segmentKind is the type of a path pattern segment
+type segmentKind int
+
+const (
+	// literalSegment matches a path segment with the same value
+	literalSegment segmentKind = iota
+	// wildcardSegment matches exactly one non-empty path segment. Written as * or {name}
+	wildcardSegment
+	// globSegment matches zero or more path segments. Written as **
+	globSegment
+	// templateSegment matches a path segment equal to a claim of the requester. Written as {username} or {claims.name}
+	templateSegment
+)
+
+// claimsPrefix is the prefix of templates referring to claims by name, e.g. {claims.username}
+const claimsPrefix = "claims."
+
+// segment is a part of a path pattern between two slashes
+type segment struct {
+	kind segmentKind
+	// value is the literal value, the parameter name of a named segment, or the claim name of a template
+	value string
+}
+
+// pathPattern is a parsed rule path
+type pathPattern []segment
+
+// parsePathPattern parses a rule path into segments
+//	e.g. /things/*/properties -> [things * properties]
+func parsePathPattern(path string) (pathPattern, error) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("path must start with a slash: %s", path)
+	}
+	parts := splitPath(path)
+	pattern := make(pathPattern, 0, len(parts))
+	for _, part := range parts {
+		s, invalid := parseSegment(part)
+		if invalid != "" {
+			return nil, fmt.Errorf("%s in path: %s", invalid, path)
+		}
+		pattern = append(pattern, s)
+	}
+	return pattern, nil
+}
+
+// parseSegment parses a part of a rule path between two slashes
+//	If the part is invalid, it returns why.
+func parseSegment(part string) (s segment, invalid string) {
+	switch {
+	case part == "**":
+		return segment{kind: globSegment}, ""
+	case part == "*":
+		return segment{kind: wildcardSegment}, ""
+	case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}"):
+		name := part[1 : len(part)-1]
+		if name == "" || strings.ContainsAny(name, "{}*") {
+			return segment{}, "invalid named segment"
+		}
+		if claim := strings.TrimPrefix(name, claimsPrefix); claim != name || isClaimName(claim) {
+			if claim == "" {
+				return segment{}, "no claim name in template"
+			}
+			return segment{kind: templateSegment, value: claim}, ""
+		}
+		return segment{kind: wildcardSegment, value: name}, ""
+	case strings.ContainsAny(part, "{}*"):
+		return segment{}, "wildcards and named segments must span a whole segment"
+	default:
+		return segment{kind: literalSegment, value: part}, ""
+	}
+}
+
+// matchPath matches a rule path against the requested path and returns the longest matched node of the requested
+// path tree, e.g. /res when /res/123 is requested and the rule path is /res
+//	It matches like a compiled Policy without allocations. Invalid rule paths never match.
+func matchPath(rulePath, path string, claims *Claims) (node string, matched bool) {
+	if !strings.HasPrefix(rulePath, "/") || !strings.HasPrefix(path, "/") {
+		return "", false
+	}
+	m := pathMatch{path: path, claims: claims}
+	end := m.match(rulePath[1:], true, path[1:], true, 0)
+	if end == -1 {
+		return "", false
+	}
+	// only valid paths match, checked after matching as most paths do not match at all
+	for rest, more := rulePath[1:], true; more; {
+		var part string
+		part, rest, more = nextSegment(rest)
+		if _, invalid := parseSegment(part); invalid != "" {
+			return "", false
+		}
+	}
+	return path[:end], true
+}
+
+// pathMatch is the state of matching a rule path against a requested path
+type pathMatch struct {
+	path   string
+	claims *Claims
+}
+
+// match matches the remaining rule path against the remaining requested path
+//	pattern and rest are the unconsumed parts of the rule path and the requested path, patternMore and more tell
+//	whether they have any segments left, and depth is the number of consumed segments of the requested path.
+//	It returns the end of the longest matched node in the requested path, or -1 if none matched.
+func (m *pathMatch) match(pattern string, patternMore bool, rest string, more bool, depth int) int {
+	if !patternMore {
+		if depth == 0 {
+			return -1
+		}
+		if more {
+			return len(m.path) - len(rest) - 1
+		}
+		return len(m.path)
+	}
+
+	part, nextPattern, nextPatternMore := nextSegment(pattern)
+	s := segment{kind: literalSegment, value: part}
+	if part != "" && (part[0] == '*' || part[0] == '{') {
+		s, _ = parseSegment(part)
+	}
+	if s.kind == globSegment {
+		// match zero or more segments
+		longest := -1
+		for r, mo, d := rest, more, depth; ; d++ {
+			if end := m.match(nextPattern, nextPatternMore, r, mo, d); end > longest {
+				longest = end
+			}
+			if !mo {
+				break
+			}
+			_, r, mo = nextSegment(r)
+		}
+		return longest
+	}
+
+	if !more {
+		return -1
+	}
+	segment, next, nextMore := nextSegment(rest)
+	switch s.kind {
+	case wildcardSegment:
+		if segment == "" {
+			return -1
+		}
+	case templateSegment:
+		if !m.claims.hasValue(s.value, segment) {
+			return -1
+		}
+	default:
+		if segment != s.value {
+			return -1
+		}
+	}
+	return m.match(nextPattern, nextPatternMore, next, nextMore, depth+1)
+}
+
+// splitPath splits a path into its segments, dropping the empty string before the first slash
+//	e.g. /path1/path2 -> [path1 path2]
+//	e.g. / -> [""]
+func splitPath(path string) []string {
+	return strings.Split(path, "/")[1:]
+}
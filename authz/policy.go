@@ -0,0 +1,358 @@
+package authz
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Policy is an immutable, indexed form of authorization rules
+//	It is safe for concurrent use and evaluates requests without allocations.
+//	A policy is created using Conf.Compile.
+type Policy struct {
+	algorithm string
+	rules     []compiledRule
+	root      *node
+}
+
+// compiledRule is a rule without its paths, which are in the trie
+type compiledRule struct {
+	id   string
+	deny bool
+	conditions
+}
+
+// node is a node of the path trie
+type node struct {
+	literals map[string]*node
+	wildcard *node
+	glob     *node
+	// templates are the children for segments that must match the claims of the requester
+	templates []template
+	// terminals are the rule paths ending at this node
+	terminals []terminal
+}
+
+// template is a trie edge for a claim-templated segment
+type template struct {
+	claim string
+	child *node
+}
+
+// terminal refers to a rule path
+type terminal struct {
+	rule int
+	// index is the position of the path in the rule
+	index int
+	path  string
+}
+
+// anonymous are the claims of unauthenticated users
+var anonymous = Claims{Groups: []string{GroupAnonymous}}
+
+// Compile compiles the rules into a policy
+//	It fails on invalid rule paths and unknown combining algorithms.
+func (authz Conf) Compile() (*Policy, error) {
+	policy, err := authz.Rules.compile(authz.CombiningAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// compile compiles the rules into a policy
+//	On errors, it also returns a policy in which the invalid paths never match and an unknown algorithm falls back to deny-overrides.
+func (rules Rules) compile(algorithm string) (*Policy, error) {
+	var firstErr error
+	switch algorithm {
+	case DenyOverrides, PermitOverrides, FirstApplicable:
+	case "":
+		algorithm = DenyOverrides
+	default:
+		firstErr = fmt.Errorf("unknown combining algorithm: %s", algorithm)
+		algorithm = DenyOverrides
+	}
+
+	policy := &Policy{
+		algorithm: algorithm,
+		rules:     make([]compiledRule, len(rules)),
+		root:      &node{},
+	}
+	for i := range rules {
+		rule := &rules[i]
+		policy.rules[i] = compiledRule{
+			id:         rule.ID,
+			deny:       rule.Effect == EffectDeny,
+			conditions: rule.conditions(),
+		}
+
+		for j, path := range rule.paths() {
+			pattern, err := parsePathPattern(path)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			n := policy.root.insert(pattern)
+			n.terminals = append(n.terminals, terminal{rule: i, index: j, path: path})
+		}
+	}
+	return policy, firstErr
+}
+
+// insert adds the nodes of a pattern to the trie and returns the last one
+func (n *node) insert(pattern pathPattern) *node {
+	for _, s := range pattern {
+		var next **node
+		switch s.kind {
+		case globSegment:
+			next = &n.glob
+		case wildcardSegment:
+			next = &n.wildcard
+		case templateSegment:
+			n = n.template(s.value)
+			continue
+		default:
+			if n.literals == nil {
+				n.literals = make(map[string]*node)
+			}
+			child := n.literals[s.value]
+			if child == nil {
+				child = &node{}
+				n.literals[s.value] = child
+			}
+			n = child
+			continue
+		}
+		if *next == nil {
+			*next = &node{}
+		}
+		n = *next
+	}
+	return n
+}
+
+// template returns the child for the given claim template, adding it if necessary
+func (n *node) template(claim string) *node {
+	for _, t := range n.templates {
+		if t.claim == claim {
+			return t.child
+		}
+	}
+	child := &node{}
+	n.templates = append(n.templates, template{claim: claim, child: child})
+	return child
+}
+
+// Authorized checks whether a request is authorized given the path, method, and claims
+func (p *Policy) Authorized(path, method string, claims *Claims) bool {
+	e := evaluation{policy: p, path: path, method: method, claims: claims, first: -1}
+	e.run()
+
+	switch p.algorithm {
+	case FirstApplicable:
+		return e.first != -1 && !p.rules[e.first].deny
+	default:
+		return e.allowed && !e.denied
+	}
+}
+
+// Decide is similar to Authorized but returns the decision together with an explanation
+func (p *Policy) Decide(path, method string, claims *Claims) Decision {
+	e := evaluation{policy: p, path: path, method: method, claims: claims, first: -1, candidates: make(map[int]candidate)}
+	e.run()
+
+	d := decider{algorithm: p.algorithm, decision: Decision{Rule: -1}}
+	for i := range p.rules {
+		rule := &p.rules[i]
+		c, found := e.candidates[i]
+		if !found {
+			continue
+		}
+		if d.add(i, rule.id, rule.deny, c, rule.mismatch(path, method, e.claims)) {
+			break
+		}
+	}
+	return d.result()
+}
+
+// decider combines the candidate rules of a request, in the order of rules, into a decision
+type decider struct {
+	algorithm  string
+	decision   Decision
+	decided    bool
+	mismatches []Mismatch
+}
+
+// add adds a candidate rule, which applies to the request unless there is a reason for a mismatch
+//	It returns true when the request is allowed and no further candidates are needed.
+func (d *decider) add(rule int, id string, deny bool, c candidate, mismatch string) bool {
+	if mismatch != "" {
+		d.mismatches = append(d.mismatches, Mismatch{Rule: rule, RuleID: id, Path: c.path, Reason: mismatch})
+		return false
+	}
+	if d.decided {
+		return false
+	}
+
+	matched := Decision{
+		Allowed: !deny,
+		Rule:    rule,
+		RuleID:  id,
+		Path:    c.path,
+		Node:    c.node,
+	}
+	switch d.algorithm {
+	case PermitOverrides:
+		d.decided = !deny
+	case FirstApplicable:
+		d.decided = true
+	default: // DenyOverrides
+		d.decided = deny
+	}
+	if d.decided || d.decision.Rule == -1 {
+		d.decision = matched
+	}
+	return d.decided && d.decision.Allowed
+}
+
+// result returns the decision, with the mismatches if denied
+func (d *decider) result() Decision {
+	if !d.decision.Allowed {
+		d.decision.Mismatches = d.mismatches
+	}
+	return d.decision
+}
+
+// evaluation is the state of a walk through the path trie for a single request
+type evaluation struct {
+	policy *Policy
+	path   string
+	method string
+	claims *Claims
+	// allowed and denied tell whether an allow or deny rule applies
+	allowed, denied bool
+	// first is the index of the first applicable rule
+	first int
+	// candidates collects the rules with matching paths, instead of evaluating them
+	candidates map[int]candidate
+}
+
+// candidate is a rule with a matching path
+type candidate struct {
+	path string
+	node string
+	// index is the position of the path in the rule
+	index int
+}
+
+func (e *evaluation) run() {
+	if e.claims == nil {
+		e.claims = &anonymous
+	}
+	if !strings.HasPrefix(e.path, "/") {
+		return
+	}
+	e.visit(e.policy.root, e.path[1:], true, 0)
+}
+
+// visit evaluates the rules of a node and continues the walk to the nodes matching the remaining path
+//	rest is the unconsumed part of the path, more tells whether rest has any segments left (the last one may be empty),
+//	and depth is the number of consumed segments.
+//	It returns true when the outcome is decided and the walk can stop.
+func (e *evaluation) visit(n *node, rest string, more bool, depth int) bool {
+	if depth > 0 && len(n.terminals) != 0 {
+		// the path up to here is a node of the requested path tree
+		end := len(e.path)
+		if more {
+			end -= len(rest) + 1
+		}
+		for _, t := range n.terminals {
+			if e.evaluate(t, e.path[:end]) {
+				return true
+			}
+		}
+	}
+
+	if n.glob != nil {
+		// match zero or more segments
+		r, m, d := rest, more, depth
+		for {
+			if e.visit(n.glob, r, m, d) {
+				return true
+			}
+			if !m {
+				break
+			}
+			_, r, m = nextSegment(r)
+			d++
+		}
+	}
+
+	if !more {
+		return false
+	}
+	segment, next, nextMore := nextSegment(rest)
+	if child := n.literals[segment]; child != nil {
+		if e.visit(child, next, nextMore, depth+1) {
+			return true
+		}
+	}
+	if n.wildcard != nil && segment != "" {
+		if e.visit(n.wildcard, next, nextMore, depth+1) {
+			return true
+		}
+	}
+	for _, t := range n.templates {
+		if e.claims.hasValue(t.claim, segment) {
+			if e.visit(t.child, next, nextMore, depth+1) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// evaluate applies a rule whose path matches the given node
+//	It returns true when the outcome is decided.
+func (e *evaluation) evaluate(t terminal, node string) bool {
+	if e.candidates != nil {
+		// like Rule.matchPath, take the path with the longest node, then the first one listed in the rule
+		c, found := e.candidates[t.rule]
+		if !found || len(node) > len(c.node) || len(node) == len(c.node) && t.index < c.index {
+			e.candidates[t.rule] = candidate{path: t.path, node: node, index: t.index}
+		}
+		return false
+	}
+
+	rule := &e.policy.rules[t.rule]
+	if !rule.applies(e.path, e.method, e.claims) {
+		return false
+	}
+	switch e.policy.algorithm {
+	case PermitOverrides:
+		if !rule.deny {
+			e.allowed = true
+			return true
+		}
+	case FirstApplicable:
+		if e.first == -1 || t.rule < e.first {
+			e.first = t.rule
+		}
+	default: // DenyOverrides
+		if rule.deny {
+			e.denied = true
+			return true
+		}
+		e.allowed = true
+	}
+	return false
+}
+
+// nextSegment splits the first segment from the rest of the path
+func nextSegment(rest string) (segment, next string, more bool) {
+	if i := strings.IndexByte(rest, '/'); i >= 0 {
+		return rest[:i], rest[i+1:], true
+	}
+	return rest, "", false
+}
@@ -2,4 +2,7 @@ module github.com/linksmart/go-sec
 
 go 1.13
 
-require github.com/dgrijalva/jwt-go v3.0.0+incompatible
+require (
+	github.com/dgrijalva/jwt-go v3.0.0+incompatible
+	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
+)